@@ -6,18 +6,22 @@ require (
 	github.com/BishopFox/jsluice v0.0.0-20240110145140-0ddfab153e06
 	github.com/antchfx/htmlquery v1.3.2
 	github.com/antchfx/xmlquery v1.4.1
+	github.com/antchfx/xpath v1.3.1
+	github.com/mattn/go-sqlite3 v1.14.22
 	github.com/spaolacci/murmur3 v1.1.0
+	go.etcd.io/bbolt v1.3.10
+	go.starlark.net v0.0.0-20231121155337-90ade8b19d09
+	golang.org/x/net v0.15.0
 	gopkg.in/yaml.v2 v2.4.0
 )
 
 require (
 	github.com/PuerkitoBio/goquery v1.8.1 // indirect
 	github.com/andybalholm/cascadia v1.3.2 // indirect
-	github.com/antchfx/xpath v1.3.1 // indirect
 	github.com/ditashi/jsbeautifier-go v0.0.0-20141206144643-2520a8026a9c // indirect
 	github.com/golang/groupcache v0.0.0-20210331224755-41bb18bfe9da // indirect
 	github.com/smacker/go-tree-sitter v0.0.0-20230720070738-0d0a9f78d8f8 // indirect
 	golang.org/x/exp v0.0.0-20230905200255-921286631fa9 // indirect
-	golang.org/x/net v0.15.0 // indirect
+	golang.org/x/sys v0.12.0 // indirect
 	golang.org/x/text v0.13.0 // indirect
 )
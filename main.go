@@ -1,134 +1,405 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
+	"flag"
 	"fmt"
-	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
 	"strings"
-	"sync"
+	"syscall"
+	"time"
 
 	"github.com/mhmdiaa/chronos/v2/modules"
+	"github.com/mhmdiaa/chronos/v2/pkg/chronos"
 	"github.com/mhmdiaa/chronos/v2/pkg/config"
+	"github.com/mhmdiaa/chronos/v2/pkg/dedup"
 	"github.com/mhmdiaa/chronos/v2/pkg/logger"
-	"github.com/mhmdiaa/chronos/v2/pkg/wayback"
+	"github.com/mhmdiaa/chronos/v2/pkg/notify"
+	"github.com/mhmdiaa/chronos/v2/pkg/output"
+	"github.com/mhmdiaa/chronos/v2/pkg/progress"
+	"github.com/mhmdiaa/chronos/v2/pkg/report"
+	"github.com/mhmdiaa/chronos/v2/pkg/server"
+	"github.com/mhmdiaa/chronos/v2/pkg/watch"
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "report":
+			runReport(config.NewReportConfig(os.Args[2:]))
+			return
+		case "serve":
+			runServe(config.NewServeConfig(os.Args[2:]))
+			return
+		}
+	}
+
+	// Errors are returned rather than fatal, so that the output and
+	// notifications are flushed by the deferred calls before exiting
+	if err := run(); err != nil {
+		logger.Error.Fatal(err)
+	}
+}
+
+func run() error {
+	startedAt := time.Now()
 	conf := config.NewConfig()
-	err := logger.Init(conf.OutputFile)
+	if conf.ProgressEvery <= 0 {
+		return fmt.Errorf("-progress-interval must be positive")
+	}
+	// Only jsonl output stays valid when appended to
+	err := logger.Init(conf.OutputFile, conf.Format == output.FormatJSONL, !conf.NoStdout)
 	if err != nil {
-		log.Fatalf("failed to create the output logger: %v", err)
+		return fmt.Errorf("failed to create the output logger: %v", err)
 	}
 	defer logger.Close()
 
-	if conf.ListModules {
-		for _, module := range modules.ModuleRegistry {
-			fmt.Println(module.Name())
-			fmt.Printf("    %s\n", module.Description())
+	out, err := output.NewWriter(conf.Format, logger.Output.Writer())
+	if err != nil {
+		return err
+	}
+	if conf.OutputDir != "" {
+		out, err = output.NewDir(conf.OutputDir, conf.Format, out)
+		if err != nil {
+			return err
+		}
+	}
+	defer func() {
+		if err := out.Close(); err != nil {
+			logger.Error.Println(err)
+		}
+	}()
+	write := func(v interface{}) {
+		if err := out.Write(v); err != nil {
+			logger.Error.Println(err)
 		}
-		return
 	}
 
-	if conf.Target == "" {
-		logger.Error.Fatal("target not specified")
+	if len(conf.Notify) > 0 {
+		var template []byte
+		if conf.NotifyTemplate != "" {
+			template, err = os.ReadFile(conf.NotifyTemplate)
+			if err != nil {
+				return fmt.Errorf("failed to read the notification template: %v", err)
+			}
+		}
+		notifier, err := notify.New(conf.Notify, notify.Options{
+			Modules:   splitList(conf.NotifyModules),
+			Labels:    splitList(conf.NotifyLabels),
+			Template:  string(template),
+			BatchSize: conf.NotifyBatch,
+			Interval:  conf.NotifyInterval,
+			Retries:   conf.NotifyRetries,
+			Logger:    logger.Default(),
+		})
+		if err != nil {
+			return err
+		}
+		defer notifier.Close()
+		writeOutput := write
+		write = func(v interface{}) {
+			writeOutput(v)
+			notifier.Notify(v)
+		}
 	}
 
-	logger.Info.Printf("Searching for snapshots...")
-	snapshotLocationsList, err := wayback.SearchForSnapshots(conf.BaseURL, conf.Target, conf.Filters)
+	var emitRecord func(logger.Record)
+	if conf.EmitErrors {
+		if output.IsTable(conf.Format) && conf.ErrorsFile == "" {
+			return fmt.Errorf("-emit-errors can't be used with the %s format, use -errors-output instead", conf.Format)
+		}
+		emitRecord = func(record logger.Record) {
+			write(record)
+		}
+	}
+	err = logger.InitRecords(emitRecord, conf.ErrorsFile)
 	if err != nil {
-		logger.Error.Fatal(err)
+		return fmt.Errorf("failed to create the errors logger: %v", err)
 	}
-	logger.Info.Printf("Found %d snapshots\n", len(snapshotLocationsList))
 
-	// If no modules are enabled, write snapshot locations and exit
-	if conf.Modules == "" {
-		for _, snapshot := range snapshotLocationsList {
-			j, err := json.Marshal(snapshot)
-			if err != nil {
-				logger.Error.Println(err)
-				continue
-			}
-			logger.Output.Println(string(j))
+	for _, plugin := range conf.Plugins {
+		name, command, found := strings.Cut(plugin, "=")
+		if !found {
+			return fmt.Errorf("invalid plugin format: %s", plugin)
+		}
+		if err := modules.RegisterPlugin(name, strings.Fields(command)); err != nil {
+			return err
 		}
-		return
 	}
 
-	// Set up modules
-	moduleNames := strings.Split(conf.Modules, ",")
-	enabledModules := []modules.Module{}
-	for _, moduleName := range moduleNames {
-		if module, exists := modules.ModuleRegistry[moduleName]; exists {
-			enabledModules = append(enabledModules, module)
-		} else {
-			logger.Error.Fatalf("Module %s not found", moduleName)
+	if conf.ListModules {
+		names := make([]string, 0, len(modules.ModuleRegistry))
+		for name := range modules.ModuleRegistry {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Println(modules.Usage(modules.ModuleRegistry[name]()))
 		}
+		return nil
 	}
 
-	var moduleWg sync.WaitGroup
-	moduleWg.Add(len(enabledModules))
-	outputChan := make(chan modules.ModuleOutput)
+	if conf.Target == "" {
+		return fmt.Errorf("target not specified")
+	}
 
 	var moduleConfig map[string]modules.ModuleConfig
-	if conf.ConfigFile != "" {
-		moduleConfig = modules.ParseModuleConfigFile(conf.ConfigFile)
-	} else {
-		moduleConfig = modules.ParseModuleOptions(conf.ModuleOptions)
+	if conf.Modules != "" {
+		if conf.ConfigFile != "" {
+			moduleConfig, err = modules.ParseModuleConfigFile(conf.ConfigFile)
+		} else {
+			moduleConfig, err = modules.ParseModuleOptions(conf.ModuleOptions)
+		}
+		if err != nil {
+			return err
+		}
+
+		if conf.OutputDir != "" {
+			// Save the content of snapshots to the output directory by default
+			for _, id := range strings.Split(conf.Modules, ",") {
+				if name, _, _ := strings.Cut(id, ":"); name != "full" {
+					continue
+				}
+				if moduleConfig[id] == nil {
+					moduleConfig[id] = modules.ModuleConfig{}
+				}
+				if _, exists := moduleConfig[id]["dir"]; !exists {
+					moduleConfig[id]["dir"] = conf.OutputDir
+				}
+			}
+		}
 	}
 
-	for _, module := range enabledModules {
-		config := moduleConfig[module.Name()]
-		go module.Process(module.Channel(), outputChan, &moduleWg, config)
+	options := chronos.Options{
+		Target:       conf.Target,
+		Modules:      splitList(conf.Modules),
+		ModuleConfig: moduleConfig,
+		Filters:      conf.Filters,
+		Threads:      conf.Threads,
+		BaseURL:      conf.BaseURL,
+		Logger:       logger.Default(),
+		ChainDepth:   conf.ChainDepth,
+		ChainModules: splitList(conf.ChainModules),
+		ChainHosts:   splitList(conf.ChainHosts),
+		Timeline:     conf.Timeline,
+		Dedup:        conf.Dedup,
+		DedupCounts:  conf.DedupCounts,
+		DedupStore:   conf.DedupStore,
+		Database:     conf.OutputDB,
+	}
+	if conf.Watch > 0 {
+		return runWatch(conf, options, write)
 	}
 
-	// Set up snapshot workers
-	numOfWorkers := conf.Threads
-	snapshotLocationsChan := make(chan wayback.Snapshot)
-	snapshotsChan := make(chan wayback.Snapshot)
+	scanner := chronos.NewScanner(options)
+	results, err := scanner.Run(context.Background())
+	if err != nil {
+		return err
+	}
 
-	var snapshotWg sync.WaitGroup
-	snapshotWg.Add(numOfWorkers)
+	tracker := scanner.Tracker()
+	var reporter *progress.Reporter
+	if tracker != nil && !conf.NoProgress {
+		reporter = progress.NewReporter(tracker, conf.ProgressEvery, func(status string) {
+			logger.Info.Println(status)
+		})
+		if reporter.IsTerminal() {
+			logger.SetStderr(reporter)
+		}
+		reporter.Start()
+	}
 
-	for i := 0; i < numOfWorkers; i++ {
-		go wayback.FetchSnapshots(snapshotLocationsChan, snapshotsChan, &snapshotWg)
+	for result := range results {
+		write(result)
 	}
 
-	go func() {
-		for _, location := range snapshotLocationsList {
-			snapshotLocationsChan <- location
+	if reporter != nil {
+		reporter.Stop()
+	}
+	if conf.RunInfoFile != "" {
+		stats := progress.Stats{}
+		if tracker != nil {
+			stats = tracker.Stats()
 		}
-		close(snapshotLocationsChan)
-	}()
+		saveRunInfo(conf, startedAt, scanner.Snapshots(), stats)
+	}
+	if tracker != nil {
+		for _, line := range tracker.Summary() {
+			logger.Info.Println(line)
+		}
+	}
+	return nil
+}
 
-	go func() {
-		snapshotWg.Wait()
-		close(snapshotsChan)
-	}()
+func runWatch(conf config.Config, options chronos.Options, write func(v interface{})) error {
+	if conf.WatchState == "" {
+		return fmt.Errorf("-watch requires -watch-state")
+	}
+	limitSet := false
+	flag.Visit(func(f *flag.Flag) {
+		limitSet = limitSet || f.Name == "limit"
+	})
+	conflicts := []struct {
+		flag string
+		set  bool
+	}{
+		{"-timeline", conf.Timeline},
+		{"-dedup-counts", conf.DedupCounts},
+		{"-dedup-store", conf.DedupStore != ""},
+		{"-at", conf.Filters.At != ""},
+		{"-run-info", conf.RunInfoFile != ""},
+		{"-limit", limitSet},
+	}
+	for _, conflict := range conflicts {
+		if conflict.set {
+			return fmt.Errorf("-watch can't be used with %s", conflict.flag)
+		}
+	}
 
-	// Connect the snapshot channel to the module channels
-	// Then, close the module channels once the snapshots are completely processed
-	go func() {
-		for snapshot := range snapshotsChan {
-			for _, module := range enabledModules {
-				module.Channel() <- snapshot
-			}
+	// A limit would leave out snapshots taken between polls
+	options.Filters.Limit = ""
+
+	state, err := watch.LoadState(conf.WatchState)
+	if err != nil {
+		return err
+	}
+	key := conf.Dedup
+	if key == "" {
+		key = dedup.KeyValueURL
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	watcher := &watch.Watcher{
+		Options:   options,
+		Targets:   splitList(conf.Target),
+		Interval:  conf.Watch,
+		State:     state,
+		StatePath: conf.WatchState,
+		Dedup:     key,
+		Write: func(result chronos.Result) {
+			write(result)
+		},
+	}
+	return watcher.Run(ctx)
+}
+
+func saveRunInfo(conf config.Config, startedAt time.Time, snapshots int, stats progress.Stats) {
+	err := report.WriteRunInfo(conf.RunInfoFile, report.RunInfo{
+		Target:       conf.Target,
+		Modules:      conf.Modules,
+		ModuleConfig: conf.ModuleOptions,
+		Filters:      conf.Filters.Options(),
+		StartedAt:    startedAt,
+		FinishedAt:   time.Now(),
+		Snapshots:    snapshots,
+		Stats:        stats,
+	})
+	if err != nil {
+		logger.Error.Println(err)
+	}
+}
+
+func runReport(conf config.ReportConfig) {
+	logger.Init("", false, true)
+	if len(conf.Inputs) == 0 {
+		logger.Error.Fatal("no output files specified")
+	}
+
+	var info *report.RunInfo
+	if conf.RunInfoFile != "" {
+		var err error
+		info, err = report.ReadRunInfo(conf.RunInfoFile)
+		if err != nil {
+			logger.Error.Fatal(err)
 		}
-		for _, module := range enabledModules {
-			close(module.Channel())
+	}
+
+	input, err := report.ReadInputs(conf.Inputs)
+	if err != nil {
+		logger.Error.Fatal(err)
+	}
+	if input.Unknown > 0 {
+		logger.Warn.Printf("Ignored %d values that aren't results or error records\n", input.Unknown)
+	}
+
+	f, err := os.Create(conf.OutputFile)
+	if err != nil {
+		logger.Error.Fatal(err)
+	}
+	defer f.Close()
+	if err := report.Render(f, conf.Title, info, input); err != nil {
+		logger.Error.Fatal(err)
+	}
+	logger.Info.Printf("Wrote a report of %d findings and %d error records to %s\n", len(input.Entries), len(input.Records), conf.OutputFile)
+}
+
+func runServe(conf config.ServeConfig) {
+	logger.Init("", false, false)
+	if conf.MaxScans < 1 {
+		logger.Error.Fatal("-max-scans must be at least 1")
+	}
+	if conf.MaxFinished < 0 {
+		logger.Error.Fatal("-max-finished can't be negative")
+	}
+	if conf.Threads < 1 {
+		logger.Error.Fatal("-threads must be at least 1")
+	}
+
+	run := func(ctx context.Context, request server.Request, write func(v interface{}), started func(*progress.Tracker)) error {
+		scanner := chronos.NewScanner(chronos.Options{
+			Target:       request.Target,
+			Modules:      request.Modules,
+			ModuleConfig: request.ModuleConfig,
+			Filters:      request.Filters,
+			Threads:      request.Threads,
+			BaseURL:      conf.BaseURL,
+			Logger:       logger.Default(),
+			ChainDepth:   request.ChainDepth,
+			ChainModules: request.ChainModules,
+			ChainHosts:   request.ChainHosts,
+			Timeline:     request.Timeline,
+			Dedup:        request.Dedup,
+		})
+		results, err := scanner.Run(ctx)
+		if err != nil {
+			return err
 		}
-	}()
+		if tracker := scanner.Tracker(); tracker != nil {
+			started(tracker)
+		}
+		for result := range results {
+			write(result)
+		}
+		return ctx.Err()
+	}
+	srv := server.New(run, server.Request{Filters: conf.Filters, Threads: conf.Threads}, conf.MaxScans, conf.MaxFinished)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	// Close the output channel once all modules are done writing to it
+	httpServer := &http.Server{Addr: conf.Listen, Handler: srv}
 	go func() {
-		moduleWg.Wait()
-		close(outputChan)
+		<-ctx.Done()
+		srv.Shutdown()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		httpServer.Shutdown(shutdownCtx)
 	}()
 
-	for output := range outputChan {
-		j, err := json.Marshal(output)
-		if err != nil {
-			logger.Error.Println(err)
-			continue
-		}
-		logger.Output.Println(string(j))
+	logger.Info.Printf("Listening on %s\n", conf.Listen)
+	if err := httpServer.ListenAndServe(); err != http.ErrServerClosed {
+		logger.Error.Fatal(err)
+	}
+}
+
+func splitList(list string) []string {
+	if list == "" {
+		return nil
 	}
+	return strings.Split(list, ",")
 }
@@ -1,39 +1,189 @@
 package modules
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
 	"os"
+	"reflect"
 	"strings"
-	"sync"
 
 	"github.com/mhmdiaa/chronos/v2/pkg/logger"
 	"github.com/mhmdiaa/chronos/v2/pkg/wayback"
 	"gopkg.in/yaml.v2"
 )
 
-var ModuleRegistry = make(map[string]Module)
+// ModuleRegistry maps module names to functions creating new instances of
+// the module
+var ModuleRegistry = make(map[string]func() Module)
 
-func RegisterModule(module Module) {
-	ModuleRegistry[module.Name()] = module
+func RegisterModule(newModule func() Module) {
+	ModuleRegistry[newModule().Name()] = newModule
 }
 
+// Instance is a module enabled under an ID of the form name or name:label.
+// The ID is also the key of the instance's config, so that a module can run
+// several times with different configs.
+type Instance struct {
+	ID     string
+	Label  string
+	Module Module
+	router *router
+}
+
+func NewInstance(id string) (*Instance, error) {
+	name, label, _ := strings.Cut(id, ":")
+	newModule, exists := ModuleRegistry[name]
+	if !exists {
+		return nil, fmt.Errorf("module %s not found", name)
+	}
+	return &Instance{
+		ID:     id,
+		Label:  label,
+		Module: newModule(),
+	}, nil
+}
+
+// Module processes snapshots
 type Module interface {
 	Name() string
 	Description() string
-	Process(snapshotChannel <-chan wayback.Snapshot, outputChannel chan<- ModuleOutput, wg *sync.WaitGroup, config ModuleConfig)
-	Channel() chan wayback.Snapshot
+	// Options declares the options the module's config is validated against
+	Options() []Option
+	// Route is the default selection of snapshots passed to Handle
+	Route() Route
+	// Init is called once with the module's config before any snapshot is
+	// handled
+	Init(config ModuleConfig) error
+	// Handle is called concurrently for every snapshot
+	Handle(ctx context.Context, snapshot wayback.Snapshot) ([]Result, error)
+	// Close is called once all snapshots have been handled
+	Close() error
+}
+
+// Result is a value extracted from a snapshot. Label names the value, e.g.
+// the key of the expression that matched it, and is empty for modules that
+// produce a single kind of value.
+type Result struct {
+	Label string
+	Value interface{}
+}
+
+// Values returns the individual values of the result, expanding slices
+func (result Result) Values() []interface{} {
+	v := reflect.ValueOf(result.Value)
+	if v.Kind() != reflect.Slice || v.Type().Elem().Kind() == reflect.Uint8 {
+		return []interface{}{result.Value}
+	}
+	values := make([]interface{}, v.Len())
+	for i := range values {
+		values[i] = v.Index(i).Interface()
+	}
+	return values
+}
+
+// Results are written as an object of labels to values if they're labeled,
+// and as a single value or a list of values otherwise
+type Results []Result
+
+func (results Results) MarshalJSON() ([]byte, error) {
+	labeled := make(map[string]interface{})
+	var unlabeled []interface{}
+	for _, result := range results {
+		if result.Label == "" {
+			unlabeled = append(unlabeled, result.Value)
+			continue
+		}
+		labeled[result.Label] = result.Value
+	}
+
+	switch {
+	case len(labeled) > 0:
+		return json.Marshal(labeled)
+	case len(unlabeled) == 1:
+		return json.Marshal(unlabeled[0])
+	default:
+		return json.Marshal(unlabeled)
+	}
+}
+
+// UnmarshalJSON reads results written by MarshalJSON. Objects are read as
+// labeled results, and anything else as a single unlabeled result.
+func (results *Results) UnmarshalJSON(data []byte) error {
+	*results = nil
+	if string(data) == "null" {
+		return nil
+	}
+
+	var labeled map[string]interface{}
+	if err := json.Unmarshal(data, &labeled); err == nil {
+		for label, value := range labeled {
+			*results = append(*results, Result{Label: label, Value: value})
+		}
+		return nil
+	}
+
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	*results = Results{{Value: value}}
+	return nil
 }
 
 type ModuleOutput struct {
-	Module      string      `json:"module,omitempty"`
-	URL         string      `json:"url,omitempty"`
-	SnapshotURL string      `json:"snapshot,omitempty"`
-	Results     interface{} `json:"results,omitempty"`
+	Module      string  `json:"module,omitempty"`
+	Instance    string  `json:"instance,omitempty"`
+	URL         string  `json:"url,omitempty"`
+	SnapshotURL string  `json:"snapshot,omitempty"`
+	Timestamp   string  `json:"timestamp,omitempty"`
+	Results     Results `json:"results,omitempty"`
+}
+
+// Columns and Rows flatten the output into a row for every value
+func (output ModuleOutput) Columns() []string {
+	return []string{"module", "url", "snapshot", "timestamp", "label", "value"}
+}
+
+func (output ModuleOutput) Rows() [][]string {
+	var rows [][]string
+	for _, result := range output.Results {
+		for _, value := range result.Values() {
+			rows = append(rows, []string{output.InstanceID(), output.URL, output.SnapshotURL, output.Timestamp, result.Label, FormatValue(value)})
+		}
+	}
+	return rows
+}
+
+// Group places the output in a file named after the module instance
+func (output ModuleOutput) Group() (string, string) {
+	return output.URL, output.InstanceID()
+}
+
+// FormatValue returns strings as they are and other values as JSON
+func FormatValue(value interface{}) string {
+	if s, ok := value.(string); ok {
+		return s
+	}
+	j, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return string(j)
+}
+
+// InstanceID returns the ID of the module instance that produced the output
+func (output ModuleOutput) InstanceID() string {
+	if output.Instance == "" {
+		return output.Module
+	}
+	return output.Module + ":" + output.Instance
 }
 
 type BaseModule struct {
 	name        string
 	description string
-	channel     chan wayback.Snapshot
+	logger      *logger.Logger
 }
 
 func (module *BaseModule) Name() string {
@@ -44,31 +194,56 @@ func (module *BaseModule) Description() string {
 	return module.description
 }
 
-func (module *BaseModule) Channel() chan wayback.Snapshot {
-	return module.channel
+func (module *BaseModule) Options() []Option {
+	return nil
+}
+
+func (module *BaseModule) Route() Route {
+	return Route{}
+}
+
+func (module *BaseModule) Init(config ModuleConfig) error {
+	return nil
+}
+
+func (module *BaseModule) Close() error {
+	return nil
+}
+
+// SetLogger sets the logger of the module. The runner calls it before Init.
+func (module *BaseModule) SetLogger(log *logger.Logger) {
+	module.logger = log
+}
+
+// Logger returns the logger of the module, which defaults to the global
+// loggers
+func (module *BaseModule) Logger() *logger.Logger {
+	if module.logger == nil {
+		return logger.Default()
+	}
+	return module.logger
 }
 
 func NewBaseModule(name, description string) *BaseModule {
 	return &BaseModule{
 		name:        name,
 		description: description,
-		channel:     make(chan wayback.Snapshot),
 	}
 }
 
 type ModuleConfig map[string]interface{}
 
-func ParseModuleOptions(options []string) map[string]ModuleConfig {
+func ParseModuleOptions(options []string) (map[string]ModuleConfig, error) {
 	moduleOptions := make(map[string]ModuleConfig)
 	for _, option := range options {
 		parts := strings.SplitN(option, ".", 2)
 		if len(parts) != 2 {
-			logger.Error.Fatalf("invalid config format: %s", option)
+			return nil, fmt.Errorf("invalid config format: %s", option)
 		}
 		moduleName := parts[0]
 		keyValue := strings.SplitN(parts[1], "=", 2)
 		if len(keyValue) != 2 {
-			logger.Error.Fatalf("invalid config format: %s", option)
+			return nil, fmt.Errorf("invalid config format: %s", option)
 		}
 		key := keyValue[0]
 		value := keyValue[1]
@@ -78,27 +253,27 @@ func ParseModuleOptions(options []string) map[string]ModuleConfig {
 		}
 		moduleOptions[moduleName][key] = value
 	}
-	return moduleOptions
+	return moduleOptions, nil
 }
 
-func ParseModuleConfigFile(file string) map[string]ModuleConfig {
+func ParseModuleConfigFile(file string) (map[string]ModuleConfig, error) {
 	moduleOptions := make(map[string]ModuleConfig)
 
 	yamlFile, err := os.ReadFile(file)
 	if err != nil {
-		logger.Error.Fatalf("failed to read the config file %s: %v", file, err)
+		return nil, fmt.Errorf("failed to read the config file %s: %v", file, err)
 	}
 
 	config := make(map[string]string)
 	err = yaml.Unmarshal(yamlFile, config)
 	if err != nil {
-		logger.Error.Fatalf("failed to parse the config file %s: %v", file, err)
+		return nil, fmt.Errorf("failed to parse the config file %s: %v", file, err)
 	}
 
 	for k, v := range config {
 		parts := strings.SplitN(k, ".", 2)
 		if len(parts) != 2 {
-			logger.Error.Fatalf("invalid config format: %s", k)
+			return nil, fmt.Errorf("invalid config format: %s", k)
 		}
 		moduleName := parts[0]
 		key := parts[1]
@@ -108,5 +283,5 @@ func ParseModuleConfigFile(file string) map[string]ModuleConfig {
 		}
 		moduleOptions[moduleName][key] = v
 	}
-	return moduleOptions
+	return moduleOptions, nil
 }
@@ -0,0 +1,181 @@
+package modules
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/mhmdiaa/chronos/v2/pkg/diff"
+	"github.com/mhmdiaa/chronos/v2/pkg/wayback"
+)
+
+// Lines left over by the Wayback Machine after its modifications are removed
+var waybackArtifacts = regexp.MustCompile(`(?i)web(-static)?\.archive\.org|archive\.org/includes|/_static/(js|css)/|wombat|__wm\.|_____WB\$|playback timings|FILE ARCHIVED ON|INTERNET ARCHIVE|RufflePlayer`)
+
+// Diff compares consecutive snapshots of every URL
+type Diff struct {
+	*BaseModule
+	format           string
+	context          int
+	ignoreWhitespace bool
+	ignoreWayback    bool
+
+	mu       sync.Mutex
+	versions map[string][]diffVersion
+}
+
+type diffVersion struct {
+	snapshot wayback.Snapshot
+	lines    []string
+}
+
+func init() {
+	RegisterModule(func() Module {
+		return &Diff{
+			BaseModule: NewBaseModule("diff", "Show how the content of URLs changed between snapshots"),
+		}
+	})
+}
+
+func (module *Diff) Options() []Option {
+	return []Option{
+		{
+			Name:        "format",
+			Type:        TypeString,
+			Default:     "unified",
+			Description: "Format of the differences (possible values: unified, structured)",
+			Example:     "structured",
+		},
+		{
+			Name:        "context",
+			Type:        TypeInt,
+			Default:     3,
+			Description: "Number of unchanged lines shown around changes in unified diffs",
+		},
+		{
+			Name:        "ignore-whitespace",
+			Type:        TypeBool,
+			Default:     false,
+			Description: "Ignore changes in indentation, spacing and blank lines",
+		},
+		{
+			Name:        "ignore-wayback",
+			Type:        TypeBool,
+			Default:     true,
+			Description: "Ignore lines with leftovers of the Wayback Machine's modifications",
+		},
+	}
+}
+
+func (module *Diff) Init(config ModuleConfig) error {
+	module.format = config["format"].(string)
+	if module.format != "unified" && module.format != "structured" {
+		return fmt.Errorf("unknown format %s", module.format)
+	}
+	module.context = config["context"].(int)
+	if module.context < 0 {
+		return fmt.Errorf("context can't be negative")
+	}
+	module.ignoreWhitespace = config["ignore-whitespace"].(bool)
+	module.ignoreWayback = config["ignore-wayback"].(bool)
+	module.versions = make(map[string][]diffVersion)
+	return nil
+}
+
+func (module *Diff) Handle(ctx context.Context, snapshot wayback.Snapshot) ([]Result, error) {
+	version := diffVersion{
+		snapshot: snapshot,
+		lines:    module.normalize(snapshot.Content),
+	}
+	version.snapshot.Content = ""
+
+	module.mu.Lock()
+	defer module.mu.Unlock()
+	module.versions[snapshot.OriginalURL] = append(module.versions[snapshot.OriginalURL], version)
+	return nil, nil
+}
+
+func (module *Diff) normalize(content string) []string {
+	var lines []string
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimSuffix(line, "\r")
+		if module.ignoreWayback && waybackArtifacts.MatchString(line) {
+			continue
+		}
+		if module.ignoreWhitespace {
+			line = strings.Join(strings.Fields(line), " ")
+			if line == "" {
+				continue
+			}
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// Flush compares every snapshot of a URL with the previous one
+func (module *Diff) Flush(ctx context.Context) ([]SnapshotResults, error) {
+	module.mu.Lock()
+	versions := module.versions
+	module.versions = make(map[string][]diffVersion)
+	module.mu.Unlock()
+
+	urls := make([]string, 0, len(versions))
+	for url := range versions {
+		urls = append(urls, url)
+	}
+	sort.Strings(urls)
+
+	var all []SnapshotResults
+	for _, url := range urls {
+		urlVersions := versions[url]
+		sort.Slice(urlVersions, func(i, j int) bool {
+			return urlVersions[i].snapshot.Timestamp < urlVersions[j].snapshot.Timestamp
+		})
+
+		for i := 1; i < len(urlVersions); i++ {
+			if ctx.Err() != nil {
+				return all, ctx.Err()
+			}
+			previous, current := urlVersions[i-1], urlVersions[i]
+			results := module.compare(previous, current)
+			if len(results) > 0 {
+				all = append(all, SnapshotResults{Snapshot: current.snapshot, Results: results})
+			}
+		}
+	}
+	return all, nil
+}
+
+func (module *Diff) compare(previous, current diffVersion) []Result {
+	edits := diff.Lines(previous.lines, current.lines)
+
+	var added, removed []string
+	for _, edit := range edits {
+		switch edit.Op {
+		case diff.Insert:
+			added = append(added, edit.Line)
+		case diff.Delete:
+			removed = append(removed, edit.Line)
+		}
+	}
+	if len(added) == 0 && len(removed) == 0 {
+		return nil
+	}
+
+	results := []Result{{Label: "previous", Value: previous.snapshot.SnapshotURL}}
+	if module.format == "unified" {
+		unified := diff.Unified(previous.snapshot.SnapshotURL, current.snapshot.SnapshotURL, edits, module.context)
+		return append(results, Result{Label: "diff", Value: unified})
+	}
+	if len(added) > 0 {
+		results = append(results, Result{Label: "added", Value: added})
+	}
+	if len(removed) > 0 {
+		results = append(results, Result{Label: "removed", Value: removed})
+	}
+	return results
+}
@@ -2,10 +2,9 @@ package modules
 
 import (
 	"bytes"
+	"context"
 	"encoding/base64"
-	"sync"
 
-	"github.com/mhmdiaa/chronos/v2/pkg/logger"
 	"github.com/mhmdiaa/chronos/v2/pkg/wayback"
 	"github.com/spaolacci/murmur3"
 )
@@ -15,30 +14,24 @@ type Favicon struct {
 }
 
 func init() {
-	module := &Favicon{
-		BaseModule: NewBaseModule("favicon", "Calculate favicon hashes"),
-	}
-	RegisterModule(module)
+	RegisterModule(func() Module {
+		return &Favicon{
+			BaseModule: NewBaseModule("favicon", "Calculate favicon hashes"),
+		}
+	})
 }
 
-func (module *Favicon) Process(snapshotChannel <-chan wayback.Snapshot, outputChannel chan<- ModuleOutput, wg *sync.WaitGroup, config ModuleConfig) {
-	defer wg.Done()
-	for snapshot := range snapshotChannel {
-		result := murmurhash([]byte(snapshot.Content))
+func (module *Favicon) Route() Route {
+	return Route{MatchURL: `(?i)(\.ico|/favicon[^/?]*)(\?|$)`}
+}
 
-		if result == 142044466 {
-			logger.Info.Printf("[favicon] Skipped snapshot %s: points to Wayback Machine's own favicon", snapshot.SnapshotURL)
-			continue
-		}
+func (module *Favicon) Handle(ctx context.Context, snapshot wayback.Snapshot) ([]Result, error) {
+	result := murmurhash([]byte(snapshot.Content))
 
-		output := ModuleOutput{
-			Module:      module.Name(),
-			URL:         snapshot.OriginalURL,
-			SnapshotURL: snapshot.SnapshotURL,
-			Results:     result,
-		}
-		outputChannel <- output
+	if result == 142044466 {
+		return nil, Skip("wayback favicon", "points to Wayback Machine's own favicon")
 	}
+	return []Result{{Value: result}}, nil
 }
 
 func murmurhash(data []byte) int32 {
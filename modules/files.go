@@ -0,0 +1,67 @@
+package modules
+
+import (
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/mhmdiaa/chronos/v2/pkg/wayback"
+)
+
+// localPath maps an archived URL to a relative file path of the form
+// host/path, where directories are named index. Queries are escaped and
+// appended to the name.
+func localPath(rawURL string) string {
+	if !strings.Contains(rawURL, "://") {
+		rawURL = "http://" + rawURL
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return filepath.Join("invalid", url.PathEscape(rawURL))
+	}
+
+	p := u.Path
+	if p == "" || strings.HasSuffix(p, "/") {
+		p += "index"
+	}
+	// Cleaning a rooted path removes .. elements that would leave the host
+	p = strings.TrimPrefix(path.Clean("/"+p), "/")
+	if u.RawQuery != "" {
+		p += "%3F" + url.PathEscape(u.RawQuery)
+	}
+	return filepath.Join(wayback.HostDir(rawURL), filepath.FromSlash(p))
+}
+
+// writeFile writes content to path, creating its directory
+func writeFile(path string, content string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, []byte(content), 0644)
+}
+
+// writeTempFile writes content to a new file next to path, to be renamed to
+// path, and returns the new file's path
+func writeTempFile(path string, content string) (string, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", err
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return "", err
+	}
+	_, err = f.WriteString(content)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(f.Name(), 0644)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
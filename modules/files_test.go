@@ -0,0 +1,27 @@
+package modules
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestLocalPath(t *testing.T) {
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{"http://example.com/", "example.com/index"},
+		{"https://Example.com/app.js", "example.com/app.js"},
+		{"example.com:8080/a/", "example.com_8080/a/index"},
+		{"http://example.com/a?b=c", "example.com/a%3Fb=c"},
+		{"http://example.com/../../etc/passwd", "example.com/etc/passwd"},
+		{"http://../etc/passwd", "_../etc/passwd"},
+		{"http://./index.html", "_./index.html"},
+		{"http://..:80/", ".._80/index"},
+	}
+	for _, tt := range tests {
+		if got := localPath(tt.url); got != filepath.FromSlash(tt.want) {
+			t.Errorf("localPath(%q) = %q, want %q", tt.url, got, tt.want)
+		}
+	}
+}
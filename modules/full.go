@@ -1,31 +1,55 @@
 package modules
 
 import (
-	"sync"
+	"context"
+	"fmt"
+	"path/filepath"
 
 	"github.com/mhmdiaa/chronos/v2/pkg/wayback"
 )
 
+// Full returns the content of snapshots, or saves it to files named
+// host/path@timestamp and returns their paths if dir is set
 type Full struct {
 	*BaseModule
+	dir string
 }
 
 func init() {
-	module := &Full{
-		BaseModule: NewBaseModule("full", "Get the full content of snapshots"),
+	RegisterModule(func() Module {
+		return &Full{
+			BaseModule: NewBaseModule("full", "Get the full content of snapshots"),
+		}
+	})
+}
+
+func (module *Full) Options() []Option {
+	return []Option{
+		{
+			Name:        "dir",
+			Type:        TypeString,
+			Description: "Save the content to files in this directory instead of returning it (default: the -output-dir directory, if set)",
+			Example:     "pages",
+			Path:        true,
+		},
 	}
-	RegisterModule(module)
 }
 
-func (module *Full) Process(snapshotChannel <-chan wayback.Snapshot, outputChannel chan<- ModuleOutput, wg *sync.WaitGroup, config ModuleConfig) {
-	defer wg.Done()
-	for snapshot := range snapshotChannel {
-		output := ModuleOutput{
-			Module:      module.Name(),
-			URL:         snapshot.OriginalURL,
-			SnapshotURL: snapshot.SnapshotURL,
-			Results:     snapshot.Content,
-		}
-		outputChannel <- output
+func (module *Full) Init(config ModuleConfig) error {
+	if dir, ok := config["dir"].(string); ok {
+		module.dir = dir
+	}
+	return nil
+}
+
+func (module *Full) Handle(ctx context.Context, snapshot wayback.Snapshot) ([]Result, error) {
+	if module.dir == "" {
+		return []Result{{Value: snapshot.Content}}, nil
+	}
+
+	path := filepath.Join(module.dir, localPath(snapshot.OriginalURL)+"@"+snapshot.Timestamp)
+	if err := writeFile(path, snapshot.Content); err != nil {
+		return nil, NewError("module", "write", fmt.Errorf("failed to save %s: %v", snapshot.SnapshotURL, err))
 	}
+	return []Result{{Value: path}}, nil
 }
@@ -1,60 +1,71 @@
 package modules
 
 import (
+	"context"
+	"fmt"
 	"strings"
-	"sync"
 
 	"github.com/antchfx/htmlquery"
-	"github.com/mhmdiaa/chronos/v2/pkg/logger"
+	"github.com/antchfx/xpath"
 	"github.com/mhmdiaa/chronos/v2/pkg/wayback"
 )
 
 type HTML struct {
 	*BaseModule
+	expressions map[string]*xpath.Expr
 }
 
 func init() {
-	module := &HTML{
-		BaseModule: NewBaseModule("html", "Query HTML documents using XPath expressions"),
+	RegisterModule(func() Module {
+		return &HTML{
+			BaseModule: NewBaseModule("html", "Query HTML documents using XPath expressions"),
+		}
+	})
+}
+
+func (module *HTML) Options() []Option {
+	return []Option{
+		{
+			Name:        "<label>",
+			Type:        TypeXPath,
+			Required:    true,
+			Description: "XPath expression whose matches are labeled with <label>",
+			Example:     "title=//title",
+		},
 	}
-	RegisterModule(module)
 }
 
-func (module *HTML) Process(snapshotChannel <-chan wayback.Snapshot, outputChannel chan<- ModuleOutput, wg *sync.WaitGroup, config ModuleConfig) {
-	defer wg.Done()
+func (module *HTML) Route() Route {
+	return Route{MatchMime: "text/html,application/xhtml+xml"}
+}
 
-	for snapshot := range snapshotChannel {
-		doc, err := htmlquery.Parse(strings.NewReader(snapshot.Content))
-		if err != nil {
-			logger.Warn.Printf("failed to parse %s as an HTML document", snapshot.SnapshotURL)
-			continue
+func (module *HTML) Init(config ModuleConfig) error {
+	module.expressions = make(map[string]*xpath.Expr)
+	for label, expression := range config {
+		compiled, ok := expression.(*xpath.Expr)
+		if !ok {
+			return fmt.Errorf("option %s must be an XPath expression, got %T", label, expression)
 		}
+		module.expressions[label] = compiled
+	}
+	return nil
+}
 
-		matches := make(map[string][]string)
-		for label, expression := range config {
-			var expressionMatches []string
-			nodes, err := htmlquery.QueryAll(doc, expression.(string))
-			if err != nil {
-				logger.Warn.Printf("failed to run expression %s on %s", expression, snapshot.SnapshotURL)
-				continue
-			}
-			for _, node := range nodes {
-				value := htmlquery.InnerText(node)
-				expressionMatches = append(expressionMatches, value)
-			}
-			if len(expressionMatches) > 0 {
-				matches[label] = expressionMatches
-			}
-		}
+func (module *HTML) Handle(ctx context.Context, snapshot wayback.Snapshot) ([]Result, error) {
+	doc, err := htmlquery.Parse(strings.NewReader(snapshot.Content))
+	if err != nil {
+		return nil, NewError("parse", "invalid document", fmt.Errorf("failed to parse %s as an HTML document: %v", snapshot.SnapshotURL, err))
+	}
 
-		if len(matches) > 0 {
-			output := ModuleOutput{
-				Module:      module.Name(),
-				URL:         snapshot.OriginalURL,
-				SnapshotURL: snapshot.SnapshotURL,
-				Results:     matches,
-			}
-			outputChannel <- output
+	var results []Result
+	for label, expression := range module.expressions {
+		var expressionMatches []string
+		for _, node := range htmlquery.QuerySelectorAll(doc, expression) {
+			expressionMatches = append(expressionMatches, htmlquery.InnerText(node))
+		}
+		if len(expressionMatches) > 0 {
+			results = append(results, Result{Label: label, Value: expressionMatches})
 		}
 	}
+	return results, nil
 }
@@ -1,7 +1,7 @@
 package modules
 
 import (
-	"sync"
+	"context"
 
 	"github.com/BishopFox/jsluice"
 	"github.com/mhmdiaa/chronos/v2/pkg/wayback"
@@ -12,27 +12,23 @@ type JSLuice struct {
 }
 
 func init() {
-	module := &JSLuice{
-		BaseModule: NewBaseModule("jsluice", "Extract URLs and endpoints from JavaScript code using jsluice"),
-	}
-	RegisterModule(module)
+	RegisterModule(func() Module {
+		return &JSLuice{
+			BaseModule: NewBaseModule("jsluice", "Extract URLs and endpoints from JavaScript code using jsluice"),
+		}
+	})
 }
 
-func (module *JSLuice) Process(snapshotChannel <-chan wayback.Snapshot, outputChannel chan<- ModuleOutput, wg *sync.WaitGroup, config ModuleConfig) {
-	defer wg.Done()
+func (module *JSLuice) Route() Route {
+	return Route{MatchMime: "*javascript*,*ecmascript*"}
+}
 
-	for snapshot := range snapshotChannel {
-		analyzer := jsluice.NewAnalyzer([]byte(snapshot.Content))
-		urls := analyzer.GetURLs()
+func (module *JSLuice) Handle(ctx context.Context, snapshot wayback.Snapshot) ([]Result, error) {
+	analyzer := jsluice.NewAnalyzer([]byte(snapshot.Content))
+	urls := analyzer.GetURLs()
 
-		if len(urls) > 0 {
-			output := ModuleOutput{
-				Module:      module.Name(),
-				URL:         snapshot.OriginalURL,
-				SnapshotURL: snapshot.SnapshotURL,
-				Results:     urls,
-			}
-			outputChannel <- output
-		}
+	if len(urls) == 0 {
+		return nil, nil
 	}
+	return []Result{{Value: urls}}, nil
 }
@@ -0,0 +1,223 @@
+package modules
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/antchfx/htmlquery"
+	"github.com/antchfx/xpath"
+	"github.com/mhmdiaa/chronos/v2/pkg/wayback"
+	"golang.org/x/net/html"
+)
+
+// Links extracts the URLs an HTML page refers to, resolved against the page's
+// URL, and labels them as internal or external pages, or assets
+type Links struct {
+	*BaseModule
+}
+
+// Labels of links
+const (
+	linkInternal = "internal"
+	linkExternal = "external"
+	linkAsset    = "asset"
+)
+
+// linkSelectors select the elements with links in an attribute, and whether
+// the links are assets or pages
+var linkSelectors = []struct {
+	expr  *xpath.Expr
+	attr  string
+	asset bool
+}{
+	{xpath.MustCompile("//a[@href] | //area[@href]"), "href", false},
+	{xpath.MustCompile("//form[@action]"), "action", false},
+	{xpath.MustCompile("//iframe[@src] | //frame[@src]"), "src", false},
+	{xpath.MustCompile("//script[@src]"), "src", true},
+	{xpath.MustCompile("//img[@src] | //source[@src] | //video[@src] | //audio[@src] | //track[@src] | //embed[@src]"), "src", true},
+	{xpath.MustCompile("//video[@poster]"), "poster", true},
+	{xpath.MustCompile("//object[@data]"), "data", true},
+}
+
+var (
+	linkElements   = xpath.MustCompile("//link[@href]")
+	srcsetElements = xpath.MustCompile("//*[@srcset]")
+	metaElements   = xpath.MustCompile("//meta[@http-equiv][@content]")
+	styleElements  = xpath.MustCompile("//style")
+	styleAttrs     = xpath.MustCompile("//*[@style]")
+	baseElement    = xpath.MustCompile("//base[@href]")
+)
+
+// link rel values of pages rather than assets
+var pageRels = map[string]bool{
+	"canonical": true,
+	"alternate": true,
+	"next":      true,
+	"prev":      true,
+	"amphtml":   true,
+}
+
+func init() {
+	RegisterModule(func() Module {
+		return &Links{
+			BaseModule: NewBaseModule("links", "Extract links from HTML pages and label them as internal, external or assets"),
+		}
+	})
+}
+
+func (module *Links) Route() Route {
+	return Route{MatchMime: "text/html,application/xhtml+xml"}
+}
+
+func (module *Links) Handle(ctx context.Context, snapshot wayback.Snapshot) ([]Result, error) {
+	doc, err := htmlquery.Parse(strings.NewReader(snapshot.Content))
+	if err != nil {
+		return nil, NewError("parse", "invalid document", fmt.Errorf("failed to parse %s as an HTML document: %v", snapshot.SnapshotURL, err))
+	}
+	page, err := url.Parse(snapshot.OriginalURL)
+	if err != nil {
+		return nil, nil
+	}
+
+	links := newLinkSet(page, documentBase(doc, page))
+	for _, selector := range linkSelectors {
+		for _, node := range htmlquery.QuerySelectorAll(doc, selector.expr) {
+			links.add(htmlquery.SelectAttr(node, selector.attr), selector.asset)
+		}
+	}
+	for _, node := range htmlquery.QuerySelectorAll(doc, linkElements) {
+		asset := true
+		for _, rel := range strings.Fields(strings.ToLower(htmlquery.SelectAttr(node, "rel"))) {
+			if pageRels[rel] {
+				asset = false
+			}
+		}
+		links.add(htmlquery.SelectAttr(node, "href"), asset)
+	}
+	for _, node := range htmlquery.QuerySelectorAll(doc, srcsetElements) {
+		for _, candidate := range strings.Split(htmlquery.SelectAttr(node, "srcset"), ",") {
+			if fields := strings.Fields(candidate); len(fields) > 0 {
+				links.add(fields[0], true)
+			}
+		}
+	}
+	for _, node := range htmlquery.QuerySelectorAll(doc, metaElements) {
+		if strings.EqualFold(htmlquery.SelectAttr(node, "http-equiv"), "refresh") {
+			if target, ok := refreshURL(htmlquery.SelectAttr(node, "content")); ok {
+				links.add(target, false)
+			}
+		}
+	}
+	for _, node := range htmlquery.QuerySelectorAll(doc, styleElements) {
+		links.addStyle(htmlquery.InnerText(node))
+	}
+	for _, node := range htmlquery.QuerySelectorAll(doc, styleAttrs) {
+		links.addStyle(htmlquery.SelectAttr(node, "style"))
+	}
+
+	return links.results(), nil
+}
+
+// documentBase returns the URL relative links of a page are resolved
+// against, which is set by <base> if the page has one
+func documentBase(doc *html.Node, page *url.URL) *url.URL {
+	node := htmlquery.QuerySelector(doc, baseElement)
+	if node == nil {
+		return page
+	}
+	ref, err := url.Parse(strings.TrimSpace(htmlquery.SelectAttr(node, "href")))
+	if err != nil {
+		return page
+	}
+	return page.ResolveReference(ref)
+}
+
+// refreshURL returns the URL of a meta refresh's content, like
+// "5; url='/new'"
+func refreshURL(content string) (string, bool) {
+	_, target, found := strings.Cut(content, ";")
+	if !found {
+		return "", false
+	}
+	target = strings.TrimSpace(target)
+	if len(target) < 4 || !strings.EqualFold(target[:4], "url=") {
+		return "", false
+	}
+	target = strings.TrimSpace(target[4:])
+	return strings.Trim(target, `'"`), target != ""
+}
+
+// linkSet keeps the unique links of a page by label, in the order they're
+// found
+type linkSet struct {
+	page   *url.URL
+	base   *url.URL
+	seen   map[string]bool
+	labels map[string][]string
+}
+
+func newLinkSet(page, base *url.URL) *linkSet {
+	return &linkSet{
+		page:   page,
+		base:   base,
+		seen:   make(map[string]bool),
+		labels: make(map[string][]string),
+	}
+}
+
+func (links *linkSet) add(link string, asset bool) {
+	ref, err := url.Parse(strings.TrimSpace(link))
+	if err != nil {
+		return
+	}
+	target := links.base.ResolveReference(ref)
+	if target.Scheme != "http" && target.Scheme != "https" {
+		return
+	}
+	target.Fragment = ""
+	resolved := target.String()
+	if resolved == links.page.String() || links.seen[resolved] {
+		return
+	}
+	links.seen[resolved] = true
+
+	label := linkExternal
+	switch {
+	case asset:
+		label = linkAsset
+	case sameSite(links.page.Hostname(), target.Hostname()):
+		label = linkInternal
+	}
+	links.labels[label] = append(links.labels[label], resolved)
+}
+
+func (links *linkSet) addStyle(style string) {
+	for _, match := range cssLinks.FindAllStringSubmatch(style, -1) {
+		for _, link := range match[1:] {
+			if link != "" {
+				links.add(link, true)
+				break
+			}
+		}
+	}
+}
+
+func (links *linkSet) results() []Result {
+	var results []Result
+	for _, label := range []string{linkInternal, linkExternal, linkAsset} {
+		if len(links.labels[label]) > 0 {
+			results = append(results, Result{Label: label, Value: links.labels[label]})
+		}
+	}
+	return results
+}
+
+// sameSite reports whether two hosts are the same, or one is a subdomain of
+// the other, ignoring www
+func sameSite(a, b string) bool {
+	a = strings.TrimPrefix(strings.ToLower(a), "www.")
+	b = strings.TrimPrefix(strings.ToLower(b), "www.")
+	return a == b || strings.HasSuffix(a, "."+b) || strings.HasSuffix(b, "."+a)
+}
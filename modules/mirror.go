@@ -0,0 +1,365 @@
+package modules
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mhmdiaa/chronos/v2/pkg/wayback"
+)
+
+// Mirror writes the content of snapshots to a directory tree organized by
+// host and path. With the latest and closest policies, every URL is saved
+// once, and with the all policy every snapshot is saved with its timestamp
+// in the file name. Files are listed, and links rewritten, once all
+// snapshots are handled.
+type Mirror struct {
+	*BaseModule
+	dir     string
+	policy  string
+	date    time.Time
+	rewrite bool
+
+	mu    sync.Mutex
+	pages map[string]*mirrorPage
+}
+
+// mirrorPage holds the saved versions of a URL, sorted by timestamp
+type mirrorPage struct {
+	versions []mirrorFile
+}
+
+type mirrorFile struct {
+	snapshot wayback.Snapshot
+	path     string
+	kind     string
+}
+
+const (
+	mirrorLatest  = "latest"
+	mirrorClosest = "closest"
+	mirrorAll     = "all"
+)
+
+func init() {
+	RegisterModule(func() Module {
+		return &Mirror{
+			BaseModule: NewBaseModule("mirror", "Save snapshots to a browsable directory tree"),
+		}
+	})
+}
+
+func (module *Mirror) Options() []Option {
+	return []Option{
+		{
+			Name:        "dir",
+			Type:        TypeString,
+			Required:    true,
+			Description: "Directory to save the snapshots to",
+			Example:     "mirror",
+			Path:        true,
+		},
+		{
+			Name:        "policy",
+			Type:        TypeString,
+			Default:     mirrorLatest,
+			Description: "Snapshots to save for every URL (possible values: latest, closest, all)",
+			Example:     "closest",
+		},
+		{
+			Name:        "date",
+			Type:        TypeString,
+			Description: "Date the closest snapshots to are saved with the closest policy (Format: yyyyMMdd[hhmmss])",
+			Example:     "20170301",
+		},
+		{
+			Name:        "rewrite-links",
+			Type:        TypeBool,
+			Default:     false,
+			Description: "Rewrite links in HTML and CSS files to point at the saved files",
+		},
+	}
+}
+
+func (module *Mirror) Init(config ModuleConfig) error {
+	module.dir = config["dir"].(string)
+	module.policy = config["policy"].(string)
+	module.rewrite = config["rewrite-links"].(bool)
+	module.pages = make(map[string]*mirrorPage)
+
+	switch module.policy {
+	case mirrorLatest, mirrorAll:
+	case mirrorClosest:
+		date, ok := config["date"].(string)
+		if !ok {
+			return fmt.Errorf("the closest policy requires a date")
+		}
+		t, err := wayback.ParseTimestamp(date)
+		if err != nil {
+			return err
+		}
+		module.date = t
+	default:
+		return fmt.Errorf("unknown policy %s", module.policy)
+	}
+	return nil
+}
+
+func (module *Mirror) Handle(ctx context.Context, snapshot wayback.Snapshot) ([]Result, error) {
+	key := wayback.URLKey(snapshot.OriginalURL)
+	file := mirrorFile{snapshot: snapshot, kind: contentKind(snapshot.MimeType)}
+	file.snapshot.Content = ""
+
+	// Every snapshot has its own file with the all policy. Otherwise, the
+	// snapshot is written to a temporary file, which replaces the URL's file
+	// if no better snapshot was saved in the meantime, so that files are
+	// written without holding the lock.
+	if module.policy == mirrorAll {
+		file.path = mirrorPath(module.dir, snapshot.OriginalURL, snapshot.Timestamp, file.kind)
+		if err := writeFile(file.path, snapshot.Content); err != nil {
+			return nil, NewError("module", "write", fmt.Errorf("failed to save %s: %v", snapshot.SnapshotURL, err))
+		}
+		module.mu.Lock()
+		module.addVersion(key, file)
+		module.mu.Unlock()
+		return nil, nil
+	}
+
+	if !module.replaces(key, snapshot.Timestamp) {
+		return nil, nil
+	}
+	file.path = mirrorPath(module.dir, snapshot.OriginalURL, "", file.kind)
+	tmp, err := writeTempFile(file.path, snapshot.Content)
+	if err != nil {
+		return nil, NewError("module", "write", fmt.Errorf("failed to save %s: %v", snapshot.SnapshotURL, err))
+	}
+
+	module.mu.Lock()
+	defer module.mu.Unlock()
+	if !module.replacesLocked(key, snapshot.Timestamp) {
+		os.Remove(tmp)
+		return nil, nil
+	}
+	if err := os.Rename(tmp, file.path); err != nil {
+		os.Remove(tmp)
+		return nil, NewError("module", "write", fmt.Errorf("failed to save %s: %v", snapshot.SnapshotURL, err))
+	}
+	if page := module.pages[key]; page != nil {
+		if len(page.versions) > 0 && page.versions[0].path != file.path {
+			os.Remove(page.versions[0].path)
+		}
+		page.versions = nil
+	}
+	module.addVersion(key, file)
+	return nil, nil
+}
+
+// replaces reports whether a snapshot of the URL with key taken at timestamp
+// should replace the saved one
+func (module *Mirror) replaces(key, timestamp string) bool {
+	module.mu.Lock()
+	defer module.mu.Unlock()
+	return module.replacesLocked(key, timestamp)
+}
+
+func (module *Mirror) replacesLocked(key, timestamp string) bool {
+	page := module.pages[key]
+	return page == nil || len(page.versions) == 0 || module.better(timestamp, page.versions[0].snapshot.Timestamp)
+}
+
+// addVersion adds a saved file to the versions of the URL with key. The lock
+// must be held.
+func (module *Mirror) addVersion(key string, file mirrorFile) {
+	page := module.pages[key]
+	if page == nil {
+		page = &mirrorPage{}
+		module.pages[key] = page
+	}
+	page.versions = append(page.versions, file)
+	sort.Slice(page.versions, func(i, j int) bool {
+		return page.versions[i].snapshot.Timestamp < page.versions[j].snapshot.Timestamp
+	})
+}
+
+// better reports whether a snapshot taken at timestamp should replace one
+// taken at current
+func (module *Mirror) better(timestamp, current string) bool {
+	if module.policy == mirrorLatest {
+		return timestamp > current
+	}
+	t, err := wayback.ParseTimestamp(timestamp)
+	if err != nil {
+		return false
+	}
+	c, err := wayback.ParseTimestamp(current)
+	if err != nil {
+		return true
+	}
+	distance, currentDistance := t.Sub(module.date).Abs(), c.Sub(module.date).Abs()
+	return distance < currentDistance || (distance == currentDistance && timestamp < current)
+}
+
+func contentKind(mime string) string {
+	switch {
+	case strings.Contains(mime, "html"):
+		return "html"
+	case strings.Contains(mime, "css"):
+		return "css"
+	}
+	return ""
+}
+
+// mirrorPath returns the path a URL is saved to. HTML files get an .html
+// extension so that they can be opened in a browser, and the timestamp, if
+// any, is added before the extension.
+func mirrorPath(dir, rawURL, timestamp, kind string) string {
+	p := localPath(rawURL)
+	name := filepath.Base(p)
+
+	var ext string
+	if !strings.Contains(name, "%3F") {
+		ext = path.Ext(name)
+	}
+	stem := strings.TrimSuffix(name, ext)
+	if kind == "html" && ext != ".html" && ext != ".htm" {
+		stem, ext = name, ".html"
+	}
+	if timestamp != "" {
+		stem += "@" + timestamp
+	}
+	return filepath.Join(dir, filepath.Dir(p), stem+ext)
+}
+
+// Flush rewrites links if enabled, and returns the path of every saved file
+func (module *Mirror) Flush(ctx context.Context) ([]SnapshotResults, error) {
+	module.mu.Lock()
+	pages := module.pages
+	module.pages = make(map[string]*mirrorPage)
+	module.mu.Unlock()
+
+	keys := make([]string, 0, len(pages))
+	for key := range pages {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var results []SnapshotResults
+	var errs []string
+	for _, key := range keys {
+		for _, file := range pages[key].versions {
+			if ctx.Err() != nil {
+				return results, ctx.Err()
+			}
+			if module.rewrite && file.kind != "" {
+				if err := module.rewriteLinks(file, pages); err != nil {
+					errs = append(errs, err.Error())
+				}
+			}
+			results = append(results, SnapshotResults{
+				Snapshot: file.snapshot,
+				Results:  []Result{{Value: file.path}},
+			})
+		}
+	}
+
+	if len(errs) > 0 {
+		return results, NewError("module", "rewrite", fmt.Errorf("failed to rewrite links: %s", strings.Join(errs, "; ")))
+	}
+	return results, nil
+}
+
+var (
+	htmlLinks = regexp.MustCompile(`(?i)\b(?:href|src|action|poster)\s*=\s*(?:"([^"]*)"|'([^']*)')`)
+	cssLinks  = regexp.MustCompile(`(?i)url\(\s*(?:"([^"]*)"|'([^']*)'|([^'")\s]+))\s*\)`)
+)
+
+func (module *Mirror) rewriteLinks(file mirrorFile, pages map[string]*mirrorPage) error {
+	content, err := os.ReadFile(file.path)
+	if err != nil {
+		return err
+	}
+
+	base, err := url.Parse(file.snapshot.OriginalURL)
+	if err != nil {
+		return nil
+	}
+
+	rewritten := string(content)
+	if file.kind == "html" {
+		rewritten = replaceLinks(rewritten, htmlLinks, func(link string) string {
+			return module.localLink(link, base, file, pages)
+		})
+	}
+	rewritten = replaceLinks(rewritten, cssLinks, func(link string) string {
+		return module.localLink(link, base, file, pages)
+	})
+
+	if rewritten == string(content) {
+		return nil
+	}
+	return os.WriteFile(file.path, []byte(rewritten), 0644)
+}
+
+// replaceLinks replaces the first matching group of every match of re
+func replaceLinks(content string, re *regexp.Regexp, replace func(string) string) string {
+	var b strings.Builder
+	last := 0
+	for _, match := range re.FindAllStringSubmatchIndex(content, -1) {
+		for group := 1; group <= re.NumSubexp(); group++ {
+			start, end := match[2*group], match[2*group+1]
+			if start < 0 {
+				continue
+			}
+			b.WriteString(content[last:start])
+			b.WriteString(replace(content[start:end]))
+			last = end
+			break
+		}
+	}
+	b.WriteString(content[last:])
+	return b.String()
+}
+
+// localLink returns the relative path of the saved file a link points to,
+// or the link itself if it points to a URL that wasn't saved. With the all
+// policy, links point to the version closest in time to the linking file.
+func (module *Mirror) localLink(link string, base *url.URL, from mirrorFile, pages map[string]*mirrorPage) string {
+	ref, err := url.Parse(strings.TrimSpace(link))
+	if err != nil || (ref.Scheme != "" && ref.Scheme != "http" && ref.Scheme != "https") {
+		return link
+	}
+	target := base.ResolveReference(ref)
+	fragment := target.Fragment
+	target.Fragment = ""
+
+	page := pages[wayback.URLKey(target.String())]
+	if page == nil {
+		return link
+	}
+
+	to := page.versions[0]
+	if module.policy == mirrorAll {
+		fromTime, _ := wayback.ParseTimestamp(from.snapshot.Timestamp)
+		best := time.Duration(-1)
+		for _, version := range page.versions {
+			t, _ := wayback.ParseTimestamp(version.snapshot.Timestamp)
+			if d := t.Sub(fromTime).Abs(); best < 0 || d < best {
+				to, best = version, d
+			}
+		}
+	}
+
+	rel, err := filepath.Rel(filepath.Dir(from.path), to.path)
+	if err != nil {
+		return link
+	}
+	return (&url.URL{Path: filepath.ToSlash(rel), Fragment: fragment}).String()
+}
@@ -0,0 +1,188 @@
+package modules
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/antchfx/xpath"
+)
+
+type OptionType string
+
+const (
+	TypeString   OptionType = "string"
+	TypeInt      OptionType = "int"
+	TypeFloat    OptionType = "float"
+	TypeBool     OptionType = "bool"
+	TypeDuration OptionType = "duration"
+	TypeRegex    OptionType = "regex"
+	TypeXPath    OptionType = "xpath"
+)
+
+// Option describes a module config key. An option whose name is wrapped in
+// angle brackets, like <label>, matches any key that isn't declared by
+// another option. Values are converted to their type during validation:
+// TypeRegex values become *regexp.Regexp and TypeXPath values *xpath.Expr.
+type Option struct {
+	Name        string
+	Type        OptionType
+	Default     interface{}
+	Required    bool
+	Description string
+	// Example is a value for the option, or a key=value pair for options
+	// that match any key
+	Example string
+	// Path is set for options that are paths of files or directories, which
+	// scans submitted to the API server can't set
+	Path bool
+}
+
+func (option Option) matchesAnyKey() bool {
+	return strings.HasPrefix(option.Name, "<") && strings.HasSuffix(option.Name, ">")
+}
+
+// PathOptions returns the keys of the config of a module instance that are
+// paths, sorted. Configs of unknown modules have none.
+func PathOptions(id string, config ModuleConfig) []string {
+	instance, err := NewInstance(id)
+	if err != nil {
+		return nil
+	}
+	paths := make(map[string]bool)
+	for _, option := range instance.Module.Options() {
+		if option.Path {
+			paths[option.Name] = true
+		}
+	}
+	var keys []string
+	for key := range config {
+		if paths[key] {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// ValidateConfig checks config against options, returning a copy with
+// values converted to their types and defaults filled in
+func ValidateConfig(options []Option, config ModuleConfig) (ModuleConfig, error) {
+	declared := make(map[string]Option)
+	var anyKey *Option
+	for i, option := range options {
+		if option.matchesAnyKey() {
+			anyKey = &options[i]
+			continue
+		}
+		declared[option.Name] = option
+	}
+
+	validated := make(ModuleConfig)
+	anyKeyMatches := 0
+	keys := make([]string, 0, len(config))
+	for key := range config {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		option, exists := declared[key]
+		if !exists {
+			if anyKey == nil {
+				return nil, fmt.Errorf("unknown option %s", key)
+			}
+			option = *anyKey
+			anyKeyMatches++
+		}
+		value, err := convertOption(option.Type, config[key])
+		if err != nil {
+			return nil, fmt.Errorf("invalid value for option %s: %v", key, err)
+		}
+		validated[key] = value
+	}
+
+	for _, option := range options {
+		if option.matchesAnyKey() {
+			if option.Required && anyKeyMatches == 0 {
+				return nil, fmt.Errorf("at least one %s option is required", option.Name)
+			}
+			continue
+		}
+		if _, exists := validated[option.Name]; exists {
+			continue
+		}
+		if option.Required {
+			return nil, fmt.Errorf("option %s is required", option.Name)
+		}
+		if option.Default != nil {
+			validated[option.Name] = option.Default
+		}
+	}
+
+	return validated, nil
+}
+
+func convertOption(optionType OptionType, value interface{}) (interface{}, error) {
+	s, ok := value.(string)
+	if !ok {
+		s = fmt.Sprint(value)
+	}
+
+	switch optionType {
+	case TypeInt:
+		return strconv.Atoi(s)
+	case TypeFloat:
+		return strconv.ParseFloat(s, 64)
+	case TypeBool:
+		return strconv.ParseBool(s)
+	case TypeDuration:
+		return time.ParseDuration(s)
+	case TypeRegex:
+		return regexp.Compile(s)
+	case TypeXPath:
+		return xpath.Compile(s)
+	default:
+		return s, nil
+	}
+}
+
+// Usage describes a module's options along with an example command
+func Usage(module Module) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s\n", module.Name())
+	fmt.Fprintf(&b, "    %s\n", module.Description())
+
+	options := append(module.Options(), routeOptions(module.Route())...)
+	fmt.Fprintf(&b, "    Options:\n")
+	var examples []string
+	for _, option := range options {
+		attributes := []string{string(option.Type)}
+		if option.Required {
+			attributes = append(attributes, "required")
+		}
+		if option.Default != nil && option.Default != "" {
+			attributes = append(attributes, fmt.Sprintf("default: %v", option.Default))
+		}
+		fmt.Fprintf(&b, "        %s (%s)\n", option.Name, strings.Join(attributes, ", "))
+		fmt.Fprintf(&b, "            %s\n", option.Description)
+
+		switch {
+		case option.Example == "":
+		case option.matchesAnyKey():
+			examples = append(examples, fmt.Sprintf("-module-config '%s.%s'", module.Name(), option.Example))
+		default:
+			examples = append(examples, fmt.Sprintf("-module-config '%s.%s=%s'", module.Name(), option.Name, option.Example))
+		}
+	}
+
+	fmt.Fprintf(&b, "    Example:\n        chronos -target example.com -module %s", module.Name())
+	for _, example := range examples {
+		fmt.Fprintf(&b, " %s", example)
+	}
+	fmt.Fprintln(&b)
+	return b.String()
+}
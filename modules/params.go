@@ -0,0 +1,374 @@
+package modules
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/BishopFox/jsluice"
+	"github.com/antchfx/htmlquery"
+	"github.com/antchfx/xpath"
+	"github.com/mhmdiaa/chronos/v2/pkg/wayback"
+	"golang.org/x/net/html"
+)
+
+// Params finds the forms of HTML pages, the query parameters of the links of
+// pages and scripts, and the keys of JSON data in inline scripts. At the end
+// of every run, the parameter names found so far of the endpoints with new
+// ones are listed, and once the module is closed, every name is added to the
+// wordlist.
+type Params struct {
+	*BaseModule
+	wordlist string
+
+	mu        sync.Mutex
+	endpoints map[string]*paramEndpoint
+	// changed holds the endpoints with new parameter names since the last
+	// flush
+	changed map[string]bool
+	keys    map[string]bool
+}
+
+// paramEndpoint holds the parameter names of an endpoint across snapshots,
+// and the latest snapshot they were found in
+type paramEndpoint struct {
+	snapshot wayback.Snapshot
+	params   map[string]bool
+}
+
+type paramForm struct {
+	Action string       `json:"action"`
+	Method string       `json:"method"`
+	Inputs []paramInput `json:"inputs,omitempty"`
+}
+
+type paramInput struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+	// Value is only set for hidden inputs
+	Value string `json:"value,omitempty"`
+}
+
+type endpointParams struct {
+	Endpoint string   `json:"endpoint"`
+	Params   []string `json:"params"`
+}
+
+var (
+	formElements   = xpath.MustCompile("//form")
+	inputElements  = xpath.MustCompile(".//input[@name] | .//select[@name] | .//textarea[@name] | .//button[@name]")
+	urlElements    = xpath.MustCompile("//*[@href] | //*[@src] | //*[@action]")
+	scriptElements = xpath.MustCompile("//script[not(@src)]")
+	jsonAssignment = regexp.MustCompile(`=\s*\{`)
+)
+
+func init() {
+	RegisterModule(func() Module {
+		return &Params{
+			BaseModule: NewBaseModule("params", "Find forms and parameters, and list the parameters of every endpoint"),
+		}
+	})
+}
+
+func (module *Params) Options() []Option {
+	return []Option{
+		{
+			Name:        "wordlist",
+			Type:        TypeString,
+			Description: "Path to a file to add every parameter name and JSON key to, one per line",
+			Example:     "params.txt",
+			Path:        true,
+		},
+	}
+}
+
+func (module *Params) Route() Route {
+	return Route{MatchMime: "text/html,application/xhtml+xml,*javascript*,*ecmascript*"}
+}
+
+func (module *Params) Init(config ModuleConfig) error {
+	module.wordlist, _ = config["wordlist"].(string)
+	module.endpoints = make(map[string]*paramEndpoint)
+	module.changed = make(map[string]bool)
+	module.keys = make(map[string]bool)
+	return nil
+}
+
+func (module *Params) Handle(ctx context.Context, snapshot wayback.Snapshot) ([]Result, error) {
+	page, err := url.Parse(snapshot.OriginalURL)
+	if err != nil {
+		return nil, nil
+	}
+
+	found := newParamSet()
+	if contentKind(snapshot.MimeType) != "html" {
+		found.addScript(snapshot.Content, page)
+	} else {
+		doc, err := htmlquery.Parse(strings.NewReader(snapshot.Content))
+		if err != nil {
+			return nil, NewError("parse", "invalid document", fmt.Errorf("failed to parse %s as an HTML document: %v", snapshot.SnapshotURL, err))
+		}
+		found.addDocument(doc, page)
+	}
+
+	module.aggregate(snapshot, found)
+	return found.results(), nil
+}
+
+func (module *Params) aggregate(snapshot wayback.Snapshot, found *paramSet) {
+	module.mu.Lock()
+	defer module.mu.Unlock()
+
+	add := func(endpoint string, names []string) {
+		e := module.endpoints[endpoint]
+		if e == nil {
+			e = &paramEndpoint{params: make(map[string]bool)}
+			module.endpoints[endpoint] = e
+		}
+		if snapshot.Timestamp > e.snapshot.Timestamp {
+			e.snapshot = snapshot
+			e.snapshot.Content = ""
+		}
+		for _, name := range names {
+			if !e.params[name] {
+				e.params[name] = true
+				module.changed[endpoint] = true
+			}
+			module.keys[name] = true
+		}
+	}
+	for _, form := range found.forms {
+		names := make([]string, len(form.Inputs))
+		for i, input := range form.Inputs {
+			names[i] = input.Name
+		}
+		add(form.Action, names)
+	}
+	for _, endpoint := range found.order {
+		add(endpoint, found.query[endpoint])
+	}
+	for _, key := range found.keys {
+		module.keys[key] = true
+	}
+}
+
+// Flush lists the parameter names of the endpoints with new ones since the
+// last flush, so that runs following chained URLs don't list them again
+func (module *Params) Flush(ctx context.Context) ([]SnapshotResults, error) {
+	module.mu.Lock()
+	defer module.mu.Unlock()
+
+	var results []SnapshotResults
+	for _, endpoint := range sortedKeys(module.changed) {
+		e := module.endpoints[endpoint]
+		snapshot := e.snapshot
+		snapshot.OriginalURL = endpoint
+		results = append(results, SnapshotResults{
+			Snapshot: snapshot,
+			Results:  []Result{{Label: "params", Value: sortedKeys(e.params)}},
+		})
+	}
+	module.changed = make(map[string]bool)
+	return results, nil
+}
+
+// Close adds the parameter names and JSON keys to the wordlist, keeping the
+// ones already in it
+func (module *Params) Close() error {
+	module.mu.Lock()
+	defer module.mu.Unlock()
+	if module.wordlist == "" || len(module.keys) == 0 {
+		return nil
+	}
+
+	words := make(map[string]bool)
+	data, err := os.ReadFile(module.wordlist)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read the wordlist %s: %v", module.wordlist, err)
+	}
+	for _, word := range strings.Split(string(data), "\n") {
+		if word = strings.TrimSpace(word); word != "" {
+			words[word] = true
+		}
+	}
+	for key := range module.keys {
+		words[key] = true
+	}
+	if err := writeFile(module.wordlist, strings.Join(sortedKeys(words), "\n")+"\n"); err != nil {
+		return fmt.Errorf("failed to write the wordlist %s: %v", module.wordlist, err)
+	}
+	return nil
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// paramSet holds what's found in a snapshot
+type paramSet struct {
+	forms []paramForm
+	// query holds the query and body parameter names of endpoints, in the
+	// order the endpoints are found
+	query map[string][]string
+	order []string
+	keys  []string
+	seen  map[string]bool
+}
+
+func newParamSet() *paramSet {
+	return &paramSet{
+		query: make(map[string][]string),
+		seen:  make(map[string]bool),
+	}
+}
+
+func (found *paramSet) addDocument(doc *html.Node, page *url.URL) {
+	base := documentBase(doc, page)
+
+	for _, node := range htmlquery.QuerySelectorAll(doc, formElements) {
+		form := paramForm{
+			Action: endpoint(base, htmlquery.SelectAttr(node, "action")),
+			Method: strings.ToUpper(strings.TrimSpace(htmlquery.SelectAttr(node, "method"))),
+		}
+		if form.Method == "" {
+			form.Method = "GET"
+		}
+		for _, input := range htmlquery.QuerySelectorAll(node, inputElements) {
+			field := paramInput{
+				Name: htmlquery.SelectAttr(input, "name"),
+				Type: strings.ToLower(htmlquery.SelectAttr(input, "type")),
+			}
+			switch {
+			case input.Data != "input" && input.Data != "button":
+				field.Type = input.Data
+			case field.Type == "" && input.Data == "button":
+				field.Type = "submit"
+			case field.Type == "":
+				field.Type = "text"
+			}
+			if field.Type == "hidden" {
+				field.Value = htmlquery.SelectAttr(input, "value")
+			}
+			form.Inputs = append(form.Inputs, field)
+		}
+		found.forms = append(found.forms, form)
+	}
+
+	for _, node := range htmlquery.QuerySelectorAll(doc, urlElements) {
+		for _, attr := range []string{"href", "src", "action"} {
+			if link := htmlquery.SelectAttr(node, attr); strings.Contains(link, "?") {
+				found.addURL(base, link, nil)
+			}
+		}
+	}
+
+	for _, node := range htmlquery.QuerySelectorAll(doc, scriptElements) {
+		content := htmlquery.InnerText(node)
+		if strings.Contains(strings.ToLower(htmlquery.SelectAttr(node, "type")), "json") {
+			var data interface{}
+			if json.Unmarshal([]byte(content), &data) == nil {
+				found.addKeys(data)
+			}
+			continue
+		}
+		found.addScript(content, base)
+		for _, match := range jsonAssignment.FindAllStringIndex(content, -1) {
+			var data interface{}
+			if json.NewDecoder(strings.NewReader(content[match[1]-1:])).Decode(&data) == nil {
+				found.addKeys(data)
+			}
+		}
+	}
+}
+
+func (found *paramSet) addScript(content string, base *url.URL) {
+	for _, u := range jsluice.NewAnalyzer([]byte(content)).GetURLs() {
+		found.addURL(base, u.URL, append(u.QueryParams, u.BodyParams...))
+	}
+}
+
+// addURL adds the query parameter names of a link, along with names
+func (found *paramSet) addURL(base *url.URL, link string, names []string) {
+	ref, err := url.Parse(strings.TrimSpace(link))
+	if err != nil {
+		return
+	}
+	query, _ := url.ParseQuery(ref.RawQuery)
+	for name := range query {
+		names = append(names, name)
+	}
+	if len(names) == 0 {
+		return
+	}
+
+	e := endpoint(base, link)
+	if _, exists := found.query[e]; !exists {
+		found.order = append(found.order, e)
+	}
+	for _, name := range names {
+		if name != "" && !found.seen[e+"\x00"+name] {
+			found.seen[e+"\x00"+name] = true
+			found.query[e] = append(found.query[e], name)
+		}
+	}
+}
+
+// addKeys adds the keys of every object in JSON data
+func (found *paramSet) addKeys(data interface{}) {
+	switch v := data.(type) {
+	case map[string]interface{}:
+		for _, key := range sortedKeys(v) {
+			if !found.seen["\x00"+key] {
+				found.seen["\x00"+key] = true
+				found.keys = append(found.keys, key)
+			}
+			found.addKeys(v[key])
+		}
+	case []interface{}:
+		for _, item := range v {
+			found.addKeys(item)
+		}
+	}
+}
+
+func (found *paramSet) results() []Result {
+	var results []Result
+	if len(found.forms) > 0 {
+		results = append(results, Result{Label: "forms", Value: found.forms})
+	}
+	if len(found.order) > 0 {
+		query := make([]endpointParams, len(found.order))
+		for i, e := range found.order {
+			query[i] = endpointParams{Endpoint: e, Params: found.query[e]}
+		}
+		results = append(results, Result{Label: "query", Value: query})
+	}
+	if len(found.keys) > 0 {
+		results = append(results, Result{Label: "json-keys", Value: found.keys})
+	}
+	return results
+}
+
+// endpoint resolves a link without its query and fragment
+func endpoint(base *url.URL, link string) string {
+	ref, err := url.Parse(strings.TrimSpace(link))
+	if err != nil {
+		return link
+	}
+	target := base.ResolveReference(ref)
+	target.RawQuery = ""
+	target.ForceQuery = false
+	target.Fragment = ""
+	return target.String()
+}
@@ -0,0 +1,284 @@
+package modules
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mhmdiaa/chronos/v2/pkg/wayback"
+)
+
+// Plugin runs an external executable as a module. Every snapshot is written
+// to the executable's stdin as a JSON line, and the executable must answer
+// each one with a JSON line on stdout.
+type Plugin struct {
+	*BaseModule
+	command []string
+	config  map[string]string
+	workers int
+	timeout time.Duration
+
+	pool    chan *pluginProcess
+	mu      sync.Mutex
+	running []*pluginProcess
+}
+
+type pluginRequest struct {
+	URL         string `json:"url"`
+	SnapshotURL string `json:"snapshot"`
+	Timestamp   string `json:"timestamp"`
+	MimeType    string `json:"mime"`
+	Content     string `json:"content"`
+}
+
+// pluginResponse has the shape of ModuleOutput, plus fields for reporting
+// errors and skipped snapshots
+type pluginResponse struct {
+	Results Results `json:"results"`
+	Error   string  `json:"error"`
+	Skip    string  `json:"skip"`
+}
+
+type pluginProcess struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout *bufio.Reader
+}
+
+// RegisterPlugin registers an executable as a module named name
+func RegisterPlugin(name string, command []string) error {
+	if _, exists := ModuleRegistry[name]; exists {
+		return fmt.Errorf("module %s already exists", name)
+	}
+	if len(command) == 0 {
+		return fmt.Errorf("plugin %s has no command", name)
+	}
+
+	ModuleRegistry[name] = func() Module {
+		return &Plugin{
+			BaseModule: NewBaseModule(name, "External plugin: "+strings.Join(command, " ")),
+			command:    command,
+		}
+	}
+	return nil
+}
+
+func (module *Plugin) Options() []Option {
+	return []Option{
+		{
+			Name:        "workers",
+			Type:        TypeInt,
+			Default:     1,
+			Description: "Number of plugin processes to run",
+			Example:     "4",
+		},
+		{
+			Name:        "timeout",
+			Type:        TypeDuration,
+			Default:     30 * time.Second,
+			Description: "Time to wait for the plugin to answer before restarting it",
+		},
+		{
+			Name:        "<key>",
+			Type:        TypeString,
+			Description: "Passed to the plugin as part of the JSON object in the CHRONOS_CONFIG environment variable",
+		},
+	}
+}
+
+func (module *Plugin) Init(config ModuleConfig) error {
+	module.workers = config["workers"].(int)
+	module.timeout = config["timeout"].(time.Duration)
+	if module.workers < 1 {
+		return fmt.Errorf("workers must be at least 1")
+	}
+
+	module.config = make(map[string]string)
+	for key, value := range config {
+		if key != "workers" && key != "timeout" {
+			module.config[key] = value.(string)
+		}
+	}
+
+	module.pool = make(chan *pluginProcess, module.workers)
+	for i := 0; i < module.workers; i++ {
+		p, err := module.start()
+		if err != nil {
+			module.Close()
+			return err
+		}
+		module.pool <- p
+	}
+	return nil
+}
+
+func (module *Plugin) Workers() int {
+	return module.workers
+}
+
+func (module *Plugin) start() (*pluginProcess, error) {
+	config, err := json.Marshal(module.config)
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := exec.Command(module.command[0], module.command[1:]...)
+	cmd.Env = append(os.Environ(), "CHRONOS_CONFIG="+string(config))
+	cmd.Stderr = module.Logger().Warn.Writer()
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start plugin %s: %v", module.Name(), err)
+	}
+
+	p := &pluginProcess{
+		cmd:    cmd,
+		stdin:  stdin,
+		stdout: bufio.NewReader(stdout),
+	}
+	module.mu.Lock()
+	module.running = append(module.running, p)
+	module.mu.Unlock()
+	return p, nil
+}
+
+func (module *Plugin) stop(p *pluginProcess) {
+	p.stdin.Close()
+	p.cmd.Process.Kill()
+	p.cmd.Wait()
+
+	module.mu.Lock()
+	defer module.mu.Unlock()
+	for i, running := range module.running {
+		if running == p {
+			module.running = append(module.running[:i], module.running[i+1:]...)
+			break
+		}
+	}
+}
+
+func (module *Plugin) Handle(ctx context.Context, snapshot wayback.Snapshot) ([]Result, error) {
+	var p *pluginProcess
+	select {
+	case p = <-module.pool:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	// Slots of processes that failed to restart are empty, and the process is
+	// started again by the next snapshot, so that the pool never shrinks
+	if p == nil {
+		var err error
+		if p, err = module.start(); err != nil {
+			module.pool <- nil
+			return nil, err
+		}
+	}
+
+	response, err := module.exchange(ctx, p, snapshot)
+	if err != nil {
+		// The process is in an unknown state, so replace it
+		module.stop(p)
+		var startErr error
+		if p, startErr = module.start(); startErr != nil {
+			module.Logger().Error.Println(startErr)
+		}
+		module.pool <- p
+		return nil, err
+	}
+	module.pool <- p
+
+	switch {
+	case response.Error != "":
+		return nil, NewError("module", "plugin error", errors.New(response.Error))
+	case response.Skip != "":
+		return nil, Skip("plugin", response.Skip)
+	}
+	return response.Results, nil
+}
+
+func (module *Plugin) exchange(ctx context.Context, p *pluginProcess, snapshot wayback.Snapshot) (*pluginResponse, error) {
+	request, err := json.Marshal(pluginRequest{
+		URL:         snapshot.OriginalURL,
+		SnapshotURL: snapshot.SnapshotURL,
+		Timestamp:   snapshot.Timestamp,
+		MimeType:    snapshot.MimeType,
+		Content:     snapshot.Content,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	type readResult struct {
+		line []byte
+		err  error
+	}
+	done := make(chan readResult, 1)
+	go func() {
+		if _, err := p.stdin.Write(append(request, '\n')); err != nil {
+			done <- readResult{err: err}
+			return
+		}
+		line, err := p.stdout.ReadBytes('\n')
+		done <- readResult{line: line, err: err}
+	}()
+
+	timer := time.NewTimer(module.timeout)
+	defer timer.Stop()
+
+	select {
+	case result := <-done:
+		if result.err != nil {
+			return nil, NewError("module", "plugin exited", fmt.Errorf("plugin %s stopped responding: %v", module.Name(), result.err))
+		}
+		var response pluginResponse
+		if err := json.Unmarshal(result.line, &response); err != nil {
+			return nil, NewError("module", "invalid response", fmt.Errorf("plugin %s returned an invalid response: %v", module.Name(), err))
+		}
+		return &response, nil
+	case <-timer.C:
+		return nil, NewError("module", "timeout", fmt.Errorf("plugin %s timed out after %s on %s", module.Name(), module.timeout, snapshot.SnapshotURL))
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Close asks the plugin processes to exit by closing their stdin, and kills
+// those that don't exit in time
+func (module *Plugin) Close() error {
+	module.mu.Lock()
+	running := append([]*pluginProcess(nil), module.running...)
+	module.running = nil
+	module.mu.Unlock()
+
+	for _, p := range running {
+		p.stdin.Close()
+		exited := make(chan struct{})
+		go func(p *pluginProcess) {
+			p.cmd.Wait()
+			close(exited)
+		}(p)
+		select {
+		case <-exited:
+		case <-time.After(5 * time.Second):
+			p.cmd.Process.Kill()
+			<-exited
+		}
+	}
+	return nil
+}
@@ -1,48 +1,57 @@
 package modules
 
 import (
+	"context"
+	"fmt"
 	"regexp"
-	"sync"
 
 	"github.com/mhmdiaa/chronos/v2/pkg/wayback"
 )
 
 type Regex struct {
 	*BaseModule
+	expressions map[string]*regexp.Regexp
 }
 
 func init() {
-	module := &Regex{
-		BaseModule: NewBaseModule("regex", "Extract regex matches"),
-	}
-	RegisterModule(module)
+	RegisterModule(func() Module {
+		return &Regex{
+			BaseModule: NewBaseModule("regex", "Extract regex matches"),
+		}
+	})
 }
 
-func (module *Regex) Process(snapshotChannel <-chan wayback.Snapshot, outputChannel chan<- ModuleOutput, wg *sync.WaitGroup, config ModuleConfig) {
-	defer wg.Done()
-
-	expressions := make(map[string]*regexp.Regexp)
-	for label, expression := range config {
-		expressions[label] = regexp.MustCompile(expression.(string))
+func (module *Regex) Options() []Option {
+	return []Option{
+		{
+			Name:        "<label>",
+			Type:        TypeRegex,
+			Required:    true,
+			Description: "Regular expression whose matches are labeled with <label>",
+			Example:     `paths=/[^\s]+`,
+		},
 	}
+}
 
-	for snapshot := range snapshotChannel {
-		matches := make(map[string][]string)
-		for label, re := range expressions {
-			reMatches := re.FindAllString(snapshot.Content, -1)
-			if len(reMatches) > 0 {
-				matches[label] = reMatches
-			}
+func (module *Regex) Init(config ModuleConfig) error {
+	module.expressions = make(map[string]*regexp.Regexp)
+	for label, expression := range config {
+		compiled, ok := expression.(*regexp.Regexp)
+		if !ok {
+			return fmt.Errorf("option %s must be a regular expression, got %T", label, expression)
 		}
+		module.expressions[label] = compiled
+	}
+	return nil
+}
 
-		if len(matches) > 0 {
-			output := ModuleOutput{
-				Module:      module.Name(),
-				URL:         snapshot.OriginalURL,
-				SnapshotURL: snapshot.SnapshotURL,
-				Results:     matches,
-			}
-			outputChannel <- output
+func (module *Regex) Handle(ctx context.Context, snapshot wayback.Snapshot) ([]Result, error) {
+	var results []Result
+	for label, re := range module.expressions {
+		reMatches := re.FindAllString(snapshot.Content, -1)
+		if len(reMatches) > 0 {
+			results = append(results, Result{Label: label, Value: reMatches})
 		}
 	}
+	return results, nil
 }
@@ -0,0 +1,87 @@
+package modules
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/mhmdiaa/chronos/v2/pkg/wayback"
+)
+
+// Route selects the snapshots a module instance handles. MatchMime is a
+// comma-separated list of MIME types, where * matches any characters, and
+// MatchURL is a regular expression matched against the original URL. Empty
+// fields match every snapshot.
+type Route struct {
+	MatchMime string
+	MatchURL  string
+}
+
+// Every instance accepts these options, which default to the module's route
+func routeOptions(route Route) []Option {
+	return []Option{
+		{
+			Name:        "match-mime",
+			Type:        TypeString,
+			Default:     route.MatchMime,
+			Description: "Comma-separated list of MIME types to handle (* matches any characters, empty matches all)",
+		},
+		{
+			Name:        "match-url",
+			Type:        TypeString,
+			Default:     route.MatchURL,
+			Description: "Regular expression matched against the URLs to handle (empty matches all)",
+		},
+	}
+}
+
+// MIME types that don't tell what a snapshot is, like those of revisits,
+// match every MIME type
+var unknownMimeTypes = map[string]bool{
+	"":                         true,
+	"unk":                      true,
+	"warc/revisit":             true,
+	"application/octet-stream": true,
+	"binary/octet-stream":      true,
+}
+
+type router struct {
+	mimeTypes []*regexp.Regexp
+	url       *regexp.Regexp
+}
+
+func newRouter(matchMime, matchURL string) (*router, error) {
+	r := &router{}
+	for _, mimeType := range strings.Split(matchMime, ",") {
+		mimeType = strings.TrimSpace(mimeType)
+		if mimeType == "" {
+			continue
+		}
+		pattern := strings.ReplaceAll(regexp.QuoteMeta(strings.ToLower(mimeType)), `\*`, ".*")
+		r.mimeTypes = append(r.mimeTypes, regexp.MustCompile("^"+pattern+"$"))
+	}
+
+	if matchURL != "" {
+		re, err := regexp.Compile(matchURL)
+		if err != nil {
+			return nil, err
+		}
+		r.url = re
+	}
+	return r, nil
+}
+
+func (r *router) matches(snapshot wayback.Snapshot) bool {
+	if r.url != nil && !r.url.MatchString(snapshot.OriginalURL) {
+		return false
+	}
+	mimeType := strings.ToLower(snapshot.MimeType)
+	if len(r.mimeTypes) == 0 || unknownMimeTypes[mimeType] {
+		return true
+	}
+	for _, re := range r.mimeTypes {
+		if re.MatchString(mimeType) {
+			return true
+		}
+	}
+	return false
+}
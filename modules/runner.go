@@ -0,0 +1,272 @@
+package modules
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/mhmdiaa/chronos/v2/pkg/logger"
+	"github.com/mhmdiaa/chronos/v2/pkg/wayback"
+)
+
+// Error describes why a module failed to handle a snapshot
+type Error struct {
+	Stage string
+	Class string
+	Err   error
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+func NewError(stage, class string, err error) error {
+	return &Error{Stage: stage, Class: class, Err: err}
+}
+
+// SkipError is returned by modules that deliberately ignore a snapshot
+type SkipError struct {
+	Class  string
+	Reason string
+}
+
+func (e *SkipError) Error() string {
+	return e.Reason
+}
+
+func Skip(class, reason string) error {
+	return &SkipError{Class: class, Reason: reason}
+}
+
+// Flusher is implemented by modules that produce results after all
+// snapshots have been handled, such as modules comparing snapshots. Flush is
+// called at the end of every run, and should reset the module's state.
+type Flusher interface {
+	Flush(ctx context.Context) ([]SnapshotResults, error)
+}
+
+// SnapshotResults are the results a module produced for a snapshot
+type SnapshotResults struct {
+	Snapshot wayback.Snapshot
+	Results  []Result
+}
+
+// Runner passes snapshots to a set of module instances and collects their
+// results
+type Runner struct {
+	instances []*Instance
+	workers   int
+	logger    *logger.Logger
+
+	// Handled, if not nil, is called whenever an instance handles a
+	// snapshot without an error, from the goroutine handling it
+	Handled func(instance *Instance, snapshot wayback.Snapshot)
+}
+
+// NewRunner creates a runner. Modules log to log if they embed BaseModule.
+func NewRunner(instances []*Instance, workers int, log *logger.Logger) *Runner {
+	return &Runner{
+		instances: instances,
+		workers:   workers,
+		logger:    log,
+	}
+}
+
+// Init validates the config of every instance and initializes it. Config
+// of IDs that match no instance is an error.
+func (r *Runner) Init(config map[string]ModuleConfig) error {
+	var unmatched []string
+	for id := range config {
+		matched := false
+		for _, instance := range r.instances {
+			if instance.ID == id {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			unmatched = append(unmatched, id)
+		}
+	}
+	if len(unmatched) > 0 {
+		sort.Strings(unmatched)
+		return fmt.Errorf("config for modules that aren't enabled: %s", strings.Join(unmatched, ", "))
+	}
+
+	for _, instance := range r.instances {
+		if m, ok := instance.Module.(interface{ SetLogger(*logger.Logger) }); ok {
+			m.SetLogger(r.logger)
+		}
+
+		options := append(instance.Module.Options(), routeOptions(instance.Module.Route())...)
+		instanceConfig, err := ValidateConfig(options, config[instance.ID])
+		if err != nil {
+			return fmt.Errorf("invalid config for module %s: %v", instance.ID, err)
+		}
+
+		instance.router, err = newRouter(instanceConfig["match-mime"].(string), instanceConfig["match-url"].(string))
+		if err != nil {
+			return fmt.Errorf("invalid config for module %s: invalid value for option match-url: %v", instance.ID, err)
+		}
+		delete(instanceConfig, "match-mime")
+		delete(instanceConfig, "match-url")
+
+		if err := instance.Module.Init(instanceConfig); err != nil {
+			return fmt.Errorf("failed to initialize module %s: %v", instance.ID, err)
+		}
+	}
+	return nil
+}
+
+// Accepts reports whether any instance handles the snapshot
+func (r *Runner) Accepts(snapshot wayback.Snapshot) bool {
+	for _, instance := range r.instances {
+		if instance.router.matches(snapshot) {
+			return true
+		}
+	}
+	return false
+}
+
+// Run sends every snapshot to the instances whose routes match it. The returned channel is closed
+// once all snapshots are handled.
+func (r *Runner) Run(ctx context.Context, snapshots <-chan wayback.Snapshot) <-chan ModuleOutput {
+	output := make(chan ModuleOutput)
+
+	var wg sync.WaitGroup
+	channels := make([]chan wayback.Snapshot, len(r.instances))
+	for i, instance := range r.instances {
+		channels[i] = make(chan wayback.Snapshot)
+		workers := r.workers
+		if w, ok := instance.Module.(interface{ Workers() int }); ok {
+			workers = w.Workers()
+		}
+		wg.Add(workers)
+		for j := 0; j < workers; j++ {
+			go func(instance *Instance, channel <-chan wayback.Snapshot) {
+				defer wg.Done()
+				for snapshot := range channel {
+					r.handle(ctx, instance, snapshot, output)
+				}
+			}(instance, channels[i])
+		}
+	}
+
+	go func() {
+		defer func() {
+			for _, channel := range channels {
+				close(channel)
+			}
+		}()
+		for snapshot := range snapshots {
+			for i, channel := range channels {
+				if !r.instances[i].router.matches(snapshot) {
+					continue
+				}
+				select {
+				case channel <- snapshot:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+
+	go func() {
+		wg.Wait()
+		for _, instance := range r.instances {
+			r.flush(ctx, instance, output)
+		}
+		close(output)
+	}()
+
+	return output
+}
+
+// Close closes every instance, returning all errors encountered
+func (r *Runner) Close() error {
+	var errs []error
+	for _, instance := range r.instances {
+		if err := instance.Module.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("failed to close module %s: %v", instance.ID, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (r *Runner) handle(ctx context.Context, instance *Instance, snapshot wayback.Snapshot, output chan<- ModuleOutput) {
+	results, err := instance.Module.Handle(ctx, snapshot)
+	if err != nil {
+		r.report(instance, snapshot, err)
+	} else if r.Handled != nil {
+		r.Handled(instance, snapshot)
+	}
+	send(ctx, instance, snapshot, results, output)
+}
+
+func (r *Runner) flush(ctx context.Context, instance *Instance, output chan<- ModuleOutput) {
+	flusher, ok := instance.Module.(Flusher)
+	if !ok {
+		return
+	}
+	snapshotResults, err := flusher.Flush(ctx)
+	if err != nil {
+		r.report(instance, wayback.Snapshot{}, err)
+	}
+	for _, r := range snapshotResults {
+		send(ctx, instance, r.Snapshot, r.Results, output)
+	}
+}
+
+func send(ctx context.Context, instance *Instance, snapshot wayback.Snapshot, results []Result, output chan<- ModuleOutput) {
+	if len(results) == 0 {
+		return
+	}
+
+	select {
+	case output <- ModuleOutput{
+		Module:      instance.Module.Name(),
+		Instance:    instance.Label,
+		URL:         snapshot.OriginalURL,
+		SnapshotURL: snapshot.SnapshotURL,
+		Timestamp:   snapshot.Timestamp,
+		Results:     results,
+	}:
+	case <-ctx.Done():
+	}
+}
+
+func (r *Runner) report(instance *Instance, snapshot wayback.Snapshot, err error) {
+	record := logger.Record{
+		Type:        logger.RecordError,
+		Module:      instance.ID,
+		URL:         snapshot.OriginalURL,
+		SnapshotURL: snapshot.SnapshotURL,
+		Stage:       "module",
+		Class:       "error",
+		Message:     err.Error(),
+	}
+
+	var skipErr *SkipError
+	var moduleErr *Error
+	switch {
+	case errors.As(err, &skipErr):
+		record.Type = logger.RecordSkip
+		record.Class = skipErr.Class
+		r.logger.Info.Printf("[%s] Skipped snapshot %s: %s", instance.ID, snapshot.SnapshotURL, skipErr.Reason)
+	case errors.As(err, &moduleErr):
+		record.Stage = moduleErr.Stage
+		record.Class = moduleErr.Class
+		r.logger.Warn.Printf("[%s] %v", instance.ID, err)
+	default:
+		r.logger.Warn.Printf("[%s] %v", instance.ID, err)
+	}
+	r.logger.LogRecord(record)
+}
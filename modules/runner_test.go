@@ -0,0 +1,59 @@
+package modules
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRunnerInitUnmatchedConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		ids     []string
+		config  map[string]ModuleConfig
+		wantErr string
+	}{
+		{
+			name:   "matched",
+			ids:    []string{"html:titles"},
+			config: map[string]ModuleConfig{"html:titles": {"title": "//title"}},
+		},
+		{
+			name:    "unmatched label",
+			ids:     []string{"html:titles"},
+			config:  map[string]ModuleConfig{"html:title": {"title": "//title"}},
+			wantErr: "html:title",
+		},
+		{
+			name: "several unmatched",
+			ids:  []string{"html"},
+			config: map[string]ModuleConfig{
+				"regex": {},
+				"html":  {"title": "//title"},
+				"diff":  {},
+			},
+			wantErr: "diff, regex",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var instances []*Instance
+			for _, id := range tt.ids {
+				instance, err := NewInstance(id)
+				if err != nil {
+					t.Fatal(err)
+				}
+				instances = append(instances, instance)
+			}
+			err := NewRunner(instances, 1, nil).Init(tt.config)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("got error %v, want one listing %s", err, tt.wantErr)
+			}
+		})
+	}
+}
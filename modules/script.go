@@ -0,0 +1,328 @@
+package modules
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/antchfx/htmlquery"
+	"github.com/antchfx/xmlquery"
+	"github.com/antchfx/xpath"
+	"github.com/mhmdiaa/chronos/v2/pkg/wayback"
+	starlarkjson "go.starlark.net/lib/json"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+)
+
+// Script runs a handle(snapshot) function defined in a Starlark file for
+// every snapshot. Scripts can't load other files or access the network or
+// file system.
+type Script struct {
+	*BaseModule
+	handle   starlark.Value
+	maxSteps uint64
+	timeout  time.Duration
+}
+
+func init() {
+	RegisterModule(func() Module {
+		return &Script{
+			BaseModule: NewBaseModule("script", "Extract data using a Starlark script"),
+		}
+	})
+}
+
+func (module *Script) Options() []Option {
+	return []Option{
+		{
+			Name:        "file",
+			Type:        TypeString,
+			Required:    true,
+			Description: "Path to a Starlark file defining a handle(snapshot) function",
+			Example:     "extract.star",
+			Path:        true,
+		},
+		{
+			Name:        "max-steps",
+			Type:        TypeInt,
+			Default:     10000000,
+			Description: "Maximum number of computation steps per snapshot",
+		},
+		{
+			Name:        "timeout",
+			Type:        TypeDuration,
+			Default:     10 * time.Second,
+			Description: "Maximum time to spend on a snapshot",
+		},
+		{
+			Name:        "<key>",
+			Type:        TypeString,
+			Description: "Available to the script in the config dict",
+		},
+	}
+}
+
+func (module *Script) Init(config ModuleConfig) error {
+	file := config["file"].(string)
+	module.maxSteps = uint64(config["max-steps"].(int))
+	module.timeout = config["timeout"].(time.Duration)
+
+	scriptConfig := starlark.NewDict(len(config))
+	for key, value := range config {
+		if key == "file" || key == "max-steps" || key == "timeout" {
+			continue
+		}
+		scriptConfig.SetKey(starlark.String(key), starlark.String(value.(string)))
+	}
+	scriptConfig.Freeze()
+
+	src, err := os.ReadFile(file)
+	if err != nil {
+		return fmt.Errorf("failed to read script %s: %v", file, err)
+	}
+
+	predeclared := starlark.StringDict{
+		"config":     scriptConfig,
+		"json":       starlarkjson.Module,
+		"struct":     starlark.NewBuiltin("struct", starlarkstruct.Make),
+		"xpath":      starlark.NewBuiltin("xpath", scriptXPath),
+		"re_findall": starlark.NewBuiltin("re_findall", scriptFindAll),
+		"re_search":  starlark.NewBuiltin("re_search", scriptSearch),
+	}
+	thread := module.newThread()
+	globals, err := starlark.ExecFile(thread, file, src, predeclared)
+	if err != nil {
+		return fmt.Errorf("failed to run script %s: %v", file, scriptError(err))
+	}
+
+	handle, ok := globals["handle"].(starlark.Callable)
+	if !ok {
+		return fmt.Errorf("script %s doesn't define a handle(snapshot) function", file)
+	}
+	module.handle = handle
+	return nil
+}
+
+func (module *Script) newThread() *starlark.Thread {
+	thread := &starlark.Thread{
+		Name: module.Name(),
+		Print: func(_ *starlark.Thread, msg string) {
+			module.Logger().Info.Printf("[%s] %s", module.Name(), msg)
+		},
+	}
+	thread.SetMaxExecutionSteps(module.maxSteps)
+	return thread
+}
+
+func (module *Script) Handle(ctx context.Context, snapshot wayback.Snapshot) ([]Result, error) {
+	ctx, cancel := context.WithTimeout(ctx, module.timeout)
+	defer cancel()
+
+	thread := module.newThread()
+	stop := context.AfterFunc(ctx, func() {
+		thread.Cancel(ctx.Err().Error())
+	})
+	defer stop()
+
+	value, err := starlark.Call(thread, module.handle, starlark.Tuple{snapshotStruct(snapshot)}, nil)
+	if err != nil {
+		return nil, NewError("module", "script error", fmt.Errorf("script failed on %s: %v", snapshot.SnapshotURL, scriptError(err)))
+	}
+
+	return scriptResults(value)
+}
+
+func scriptError(err error) error {
+	var evalErr *starlark.EvalError
+	if errors.As(err, &evalErr) {
+		return errors.New(evalErr.Backtrace())
+	}
+	return err
+}
+
+func snapshotStruct(snapshot wayback.Snapshot) *starlarkstruct.Struct {
+	headers := starlark.NewDict(len(snapshot.Headers))
+	for name, values := range snapshot.Headers {
+		headers.SetKey(starlark.String(strings.ToLower(name)), starlark.String(strings.Join(values, ", ")))
+	}
+
+	return starlarkstruct.FromStringDict(starlarkstruct.Default, starlark.StringDict{
+		"url":       starlark.String(snapshot.OriginalURL),
+		"snapshot":  starlark.String(snapshot.SnapshotURL),
+		"timestamp": starlark.String(snapshot.Timestamp),
+		"mime":      starlark.String(snapshot.MimeType),
+		"headers":   headers,
+		"content":   starlark.String(snapshot.Content),
+	})
+}
+
+// scriptResults converts the return value of handle. A dict is converted to
+// labeled results, None to no results and anything else to a single result.
+func scriptResults(value starlark.Value) ([]Result, error) {
+	if value == starlark.None {
+		return nil, nil
+	}
+
+	dict, ok := value.(*starlark.Dict)
+	if !ok {
+		v, err := fromStarlark(value)
+		if err != nil {
+			return nil, err
+		}
+		return []Result{{Value: v}}, nil
+	}
+
+	var results []Result
+	for _, item := range dict.Items() {
+		label, ok := starlark.AsString(item[0])
+		if !ok {
+			return nil, fmt.Errorf("result labels must be strings, got %s", item[0].Type())
+		}
+		if item[1] == starlark.None {
+			continue
+		}
+		v, err := fromStarlark(item[1])
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, Result{Label: label, Value: v})
+	}
+	return results, nil
+}
+
+func fromStarlark(value starlark.Value) (interface{}, error) {
+	switch v := value.(type) {
+	case starlark.NoneType:
+		return nil, nil
+	case starlark.Bool:
+		return bool(v), nil
+	case starlark.String:
+		return string(v), nil
+	case starlark.Int:
+		if i, ok := v.Int64(); ok {
+			return i, nil
+		}
+		return v.String(), nil
+	case starlark.Float:
+		return float64(v), nil
+	case *starlark.Dict:
+		m := make(map[string]interface{})
+		for _, item := range v.Items() {
+			key, ok := starlark.AsString(item[0])
+			if !ok {
+				return nil, fmt.Errorf("dict keys must be strings, got %s", item[0].Type())
+			}
+			converted, err := fromStarlark(item[1])
+			if err != nil {
+				return nil, err
+			}
+			m[key] = converted
+		}
+		return m, nil
+	case *starlarkstruct.Struct:
+		d := make(starlark.StringDict)
+		v.ToStringDict(d)
+		m := make(map[string]interface{})
+		for key, field := range d {
+			converted, err := fromStarlark(field)
+			if err != nil {
+				return nil, err
+			}
+			m[key] = converted
+		}
+		return m, nil
+	case starlark.Iterable:
+		var list []interface{}
+		iter := v.Iterate()
+		defer iter.Done()
+		var item starlark.Value
+		for iter.Next(&item) {
+			converted, err := fromStarlark(item)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, converted)
+		}
+		return list, nil
+	default:
+		return nil, fmt.Errorf("unsupported result type %s", value.Type())
+	}
+}
+
+func stringList(values []string) *starlark.List {
+	list := make([]starlark.Value, len(values))
+	for i, value := range values {
+		list[i] = starlark.String(value)
+	}
+	return starlark.NewList(list)
+}
+
+// xpath(content, expression, xml=False) returns the text of the nodes
+// matching expression in an HTML or XML document
+func scriptXPath(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var content, expression string
+	var isXML bool
+	if err := starlark.UnpackArgs(fn.Name(), args, kwargs, "content", &content, "expression", &expression, "xml?", &isXML); err != nil {
+		return nil, err
+	}
+
+	expr, err := xpath.Compile(expression)
+	if err != nil {
+		return nil, fmt.Errorf("%s: invalid expression: %v", fn.Name(), err)
+	}
+
+	var matches []string
+	if isXML {
+		doc, err := xmlquery.Parse(strings.NewReader(content))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", fn.Name(), err)
+		}
+		for _, node := range xmlquery.QuerySelectorAll(doc, expr) {
+			matches = append(matches, node.InnerText())
+		}
+	} else {
+		doc, err := htmlquery.Parse(strings.NewReader(content))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", fn.Name(), err)
+		}
+		for _, node := range htmlquery.QuerySelectorAll(doc, expr) {
+			matches = append(matches, htmlquery.InnerText(node))
+		}
+	}
+	return stringList(matches), nil
+}
+
+// re_findall(pattern, text) returns all matches of pattern in text
+func scriptFindAll(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var pattern, text string
+	if err := starlark.UnpackArgs(fn.Name(), args, kwargs, "pattern", &pattern, "text", &text); err != nil {
+		return nil, err
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", fn.Name(), err)
+	}
+	return stringList(re.FindAllString(text, -1)), nil
+}
+
+// re_search(pattern, text) returns the first match of pattern in text
+// followed by its groups, or None if there's no match
+func scriptSearch(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var pattern, text string
+	if err := starlark.UnpackArgs(fn.Name(), args, kwargs, "pattern", &pattern, "text", &text); err != nil {
+		return nil, err
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", fn.Name(), err)
+	}
+	match := re.FindStringSubmatch(text)
+	if match == nil {
+		return starlark.None, nil
+	}
+	return stringList(match), nil
+}
@@ -0,0 +1,40 @@
+package modules
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func TestSecretsInitRuleGroup(t *testing.T) {
+	tests := []struct {
+		name    string
+		group   int
+		wantErr bool
+	}{
+		{"whole match", 0, false},
+		{"capture group", 1, false},
+		{"missing group", 2, true},
+		{"negative group", -1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), "rules.yaml")
+			rules := "rules:\n  - id: custom\n    pattern: 'key=([a-z]+)'\n    group: " + strconv.Itoa(tt.group) + "\n"
+			if err := os.WriteFile(file, []byte(rules), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			module := ModuleRegistry["secrets"]()
+			config, err := ValidateConfig(module.Options(), ModuleConfig{"rules-file": file})
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = module.Init(config)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("got error %v, want error: %v", err, tt.wantErr)
+			}
+		})
+	}
+}
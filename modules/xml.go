@@ -1,60 +1,71 @@
 package modules
 
 import (
+	"context"
+	"fmt"
 	"strings"
-	"sync"
 
 	"github.com/antchfx/xmlquery"
-	"github.com/mhmdiaa/chronos/v2/pkg/logger"
+	"github.com/antchfx/xpath"
 	"github.com/mhmdiaa/chronos/v2/pkg/wayback"
 )
 
 type XML struct {
 	*BaseModule
+	expressions map[string]*xpath.Expr
 }
 
 func init() {
-	module := &XML{
-		BaseModule: NewBaseModule("xml", "Query XML documents using XPath expressions"),
+	RegisterModule(func() Module {
+		return &XML{
+			BaseModule: NewBaseModule("xml", "Query XML documents using XPath expressions"),
+		}
+	})
+}
+
+func (module *XML) Options() []Option {
+	return []Option{
+		{
+			Name:        "<label>",
+			Type:        TypeXPath,
+			Required:    true,
+			Description: "XPath expression whose matches are labeled with <label>",
+			Example:     "urls=//urlset/url/loc",
+		},
 	}
-	RegisterModule(module)
 }
 
-func (module *XML) Process(snapshotChannel <-chan wayback.Snapshot, outputChannel chan<- ModuleOutput, wg *sync.WaitGroup, config ModuleConfig) {
-	defer wg.Done()
+func (module *XML) Route() Route {
+	return Route{MatchMime: "*xml*"}
+}
 
-	for snapshot := range snapshotChannel {
-		doc, err := xmlquery.Parse(strings.NewReader(snapshot.Content))
-		if err != nil {
-			logger.Warn.Printf("failed to parse %s as an HTML document", snapshot.SnapshotURL)
-			continue
+func (module *XML) Init(config ModuleConfig) error {
+	module.expressions = make(map[string]*xpath.Expr)
+	for label, expression := range config {
+		compiled, ok := expression.(*xpath.Expr)
+		if !ok {
+			return fmt.Errorf("option %s must be an XPath expression, got %T", label, expression)
 		}
+		module.expressions[label] = compiled
+	}
+	return nil
+}
 
-		matches := make(map[string][]string)
-		for label, expression := range config {
-			var expressionMatches []string
-			nodes, err := xmlquery.QueryAll(doc, expression.(string))
-			if err != nil {
-				logger.Warn.Printf("failed to run expression %s on %s", expression, snapshot.SnapshotURL)
-				continue
-			}
-			for _, node := range nodes {
-				value := node.InnerText()
-				expressionMatches = append(expressionMatches, value)
-			}
-			if len(expressionMatches) > 0 {
-				matches[label] = expressionMatches
-			}
-		}
+func (module *XML) Handle(ctx context.Context, snapshot wayback.Snapshot) ([]Result, error) {
+	doc, err := xmlquery.Parse(strings.NewReader(snapshot.Content))
+	if err != nil {
+		return nil, NewError("parse", "invalid document", fmt.Errorf("failed to parse %s as an XML document: %v", snapshot.SnapshotURL, err))
+	}
 
-		if len(matches) > 0 {
-			output := ModuleOutput{
-				Module:      module.Name(),
-				URL:         snapshot.OriginalURL,
-				SnapshotURL: snapshot.SnapshotURL,
-				Results:     matches,
-			}
-			outputChannel <- output
+	var results []Result
+	for label, expression := range module.expressions {
+		var expressionMatches []string
+		for _, node := range xmlquery.QuerySelectorAll(doc, expression) {
+			expressionMatches = append(expressionMatches, node.InnerText())
+		}
+		if len(expressionMatches) > 0 {
+			results = append(results, Result{Label: label, Value: expressionMatches})
 		}
 	}
+	return results, nil
 }
@@ -0,0 +1,156 @@
+package chain
+
+import (
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/mhmdiaa/chronos/v2/modules"
+)
+
+// Chain turns URLs found by modules into new targets. Targets are followed
+// if they're in scope and haven't been searched before.
+type Chain struct {
+	instances map[string]bool
+	hosts     []string
+
+	mu   sync.Mutex
+	seen map[string]bool
+}
+
+// New creates a chain following the results of the given module instances,
+// or of all instances if none are given. URLs are in scope if their host
+// matches one of hosts, where *.example.com matches example.com and its
+// subdomains, or the host of the root target if hosts is empty.
+func New(root string, instances []string, hosts []string) *Chain {
+	c := &Chain{
+		instances: make(map[string]bool),
+		seen:      make(map[string]bool),
+	}
+	for _, instance := range instances {
+		c.instances[instance] = true
+	}
+
+	if len(hosts) == 0 {
+		hosts = []string{"*." + targetHost(root)}
+	}
+	for _, host := range hosts {
+		c.hosts = append(c.hosts, strings.ToLower(strings.TrimSpace(host)))
+	}
+
+	c.seen[targetKey(root)] = true
+	return c
+}
+
+// Targets returns the new targets found in output
+func (c *Chain) Targets(output modules.ModuleOutput) []string {
+	if len(c.instances) > 0 && !c.instances[output.InstanceID()] {
+		return nil
+	}
+
+	base, err := url.Parse(output.URL)
+	if err != nil {
+		return nil
+	}
+
+	var targets []string
+	for _, result := range output.Results {
+		for _, value := range result.Values() {
+			s, ok := urlValue(value)
+			if !ok {
+				continue
+			}
+			target, ok := c.resolve(base, s)
+			if !ok {
+				continue
+			}
+
+			key := targetKey(target)
+			c.mu.Lock()
+			if !c.seen[key] {
+				c.seen[key] = true
+				targets = append(targets, target)
+			}
+			c.mu.Unlock()
+		}
+	}
+	return targets
+}
+
+// urlValue returns the string of a result value, or the URL field of
+// structured values like jsluice's URLs
+func urlValue(value interface{}) (string, bool) {
+	v := reflect.ValueOf(value)
+	if v.Kind() == reflect.Pointer {
+		v = v.Elem()
+	}
+	switch v.Kind() {
+	case reflect.String:
+		return v.String(), true
+	case reflect.Struct:
+		field := v.FieldByName("URL")
+		if field.IsValid() && field.Kind() == reflect.String {
+			return field.String(), true
+		}
+	}
+	return "", false
+}
+
+// resolve turns s into a target if it's an absolute URL or a path relative
+// to base that is in scope
+func (c *Chain) resolve(base *url.URL, s string) (string, bool) {
+	s = strings.TrimSpace(s)
+	isURL := strings.HasPrefix(s, "http://") || strings.HasPrefix(s, "https://") || strings.HasPrefix(s, "//")
+	isPath := strings.HasPrefix(s, "/") || strings.HasPrefix(s, "./") || strings.HasPrefix(s, "../")
+	if !isURL && !isPath {
+		return "", false
+	}
+
+	ref, err := url.Parse(s)
+	if err != nil {
+		return "", false
+	}
+	u := base.ResolveReference(ref)
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || !c.inScope(u.Hostname()) {
+		return "", false
+	}
+
+	return u.Host + u.EscapedPath(), true
+}
+
+func (c *Chain) inScope(host string) bool {
+	host = strings.ToLower(host)
+	for _, allowed := range c.hosts {
+		if domain, found := strings.CutPrefix(allowed, "*."); found {
+			if host == domain || strings.HasSuffix(host, "."+domain) {
+				return true
+			}
+		} else if host == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// targetHost returns the host of a target like https://*.example.com/path
+func targetHost(target string) string {
+	target = strings.ToLower(target)
+	if i := strings.Index(target, "://"); i >= 0 {
+		target = target[i+3:]
+	}
+	target = strings.TrimPrefix(target, "*.")
+	if i := strings.IndexAny(target, "/:?*"); i >= 0 {
+		target = target[:i]
+	}
+	return target
+}
+
+func targetKey(target string) string {
+	target = strings.ToLower(target)
+	if i := strings.Index(target, "://"); i >= 0 {
+		target = target[i+3:]
+	}
+	target = strings.TrimPrefix(target, "www.")
+	return strings.TrimSuffix(target, "/")
+}
@@ -0,0 +1,495 @@
+// Package chronos searches for snapshots of a target in the Wayback Machine,
+// fetches them and extracts data from them with modules
+package chronos
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/mhmdiaa/chronos/v2/modules"
+	"github.com/mhmdiaa/chronos/v2/pkg/chain"
+	"github.com/mhmdiaa/chronos/v2/pkg/database"
+	"github.com/mhmdiaa/chronos/v2/pkg/dedup"
+	"github.com/mhmdiaa/chronos/v2/pkg/logger"
+	"github.com/mhmdiaa/chronos/v2/pkg/progress"
+	"github.com/mhmdiaa/chronos/v2/pkg/timeline"
+	"github.com/mhmdiaa/chronos/v2/pkg/wayback"
+)
+
+// Options configure a scan. Only Target is required.
+type Options struct {
+	Target string
+	// Modules are the IDs of the module instances to run, of the form name
+	// or name:label
+	Modules      []string
+	ModuleConfig map[string]modules.ModuleConfig
+	Filters      wayback.Filters
+	// Threads defaults to 10
+	Threads int
+	// BaseURL defaults to wayback.DefaultBaseURL
+	BaseURL string
+	// HTTPClient defaults to http.DefaultClient
+	HTTPClient *http.Client
+	// Logger defaults to a logger discarding everything
+	Logger *logger.Logger
+
+	// Snapshots of URLs found by ChainModules, or all modules, are searched
+	// for up to ChainDepth levels deep if they're in ChainHosts, or the
+	// target's host and subdomains
+	ChainDepth   int
+	ChainModules []string
+	ChainHosts   []string
+
+	// Timeline replaces module outputs with a timeline of every value found
+	Timeline bool
+	// Dedup is the key module outputs are deduplicated by, if it's not empty.
+	// See the dedup package for the other options.
+	Dedup       string
+	DedupCounts bool
+	DedupStore  string
+	// Deduper, if not nil, is used instead of creating one from the options
+	// above, so that values seen by earlier scans are left out. It isn't
+	// closed when the scan is done.
+	Deduper *dedup.Deduper
+
+	// Database is the path to a SQLite database to add snapshots, results
+	// and errors to, if it's not empty
+	Database string
+}
+
+// Result is a value found by a scan: a wayback.Snapshot if no modules are
+// enabled, and a modules.ModuleOutput otherwise. Scans with timelines
+// produce timeline.Entry values instead of module outputs, and scans with
+// deduplication counts produce dedup.Record values at the end.
+type Result interface{}
+
+// Scanner runs a scan
+type Scanner struct {
+	options Options
+	logger  *logger.Logger
+
+	snapshots    int
+	newest       string
+	oldestFailed string
+	tracker      *progress.Tracker
+}
+
+func NewScanner(options Options) *Scanner {
+	if options.Threads <= 0 {
+		options.Threads = 10
+	}
+	if options.BaseURL == "" {
+		options.BaseURL = wayback.DefaultBaseURL
+	}
+	if options.Logger == nil {
+		options.Logger = logger.Discard()
+	}
+	return &Scanner{options: options, logger: options.Logger}
+}
+
+// Run runs a scan with options until it's done or ctx is canceled
+func Run(ctx context.Context, options Options) (<-chan Result, error) {
+	return NewScanner(options).Run(ctx)
+}
+
+// Snapshots returns the number of snapshots found of the target
+func (s *Scanner) Snapshots() int {
+	return s.snapshots
+}
+
+// Newest returns the timestamp of the newest snapshot found of the target
+func (s *Scanner) Newest() string {
+	return s.newest
+}
+
+// OldestFailed returns the timestamp of the oldest snapshot that couldn't be
+// fetched, or an empty string if none failed. It's set once the results
+// channel is closed.
+func (s *Scanner) OldestFailed() string {
+	return s.oldestFailed
+}
+
+// Tracker returns the progress of fetching snapshots, or nil if no modules
+// are enabled. It's set once Run returns.
+func (s *Scanner) Tracker() *progress.Tracker {
+	return s.tracker
+}
+
+// scan holds what a running scan is made of
+type scan struct {
+	options Options
+	logger  *logger.Logger
+	client  *wayback.Client
+	runner  *modules.Runner
+	deduper *dedup.Deduper
+	db      *database.DB
+	closers []func()
+
+	oldestFailed string
+}
+
+func (sc *scan) close() {
+	for i := len(sc.closers) - 1; i >= 0; i-- {
+		sc.closers[i]()
+	}
+}
+
+// Run sets up modules and searches for snapshots of the target, returning
+// an error if either fails, and then fetches the snapshots in the
+// background. Results are sent to the returned channel, which must be read
+// until it's closed or ctx is canceled.
+func (s *Scanner) Run(ctx context.Context) (<-chan Result, error) {
+	sc, err := s.setup()
+	if err != nil {
+		return nil, err
+	}
+
+	s.logger.Info.Printf("Searching for snapshots...")
+	snapshotLocationsList, err := sc.client.SearchForSnapshots(ctx, s.options.Target, s.options.Filters)
+	if err != nil {
+		sc.close()
+		return nil, err
+	}
+	s.logger.Info.Printf("Found %d snapshots\n", len(snapshotLocationsList))
+	s.snapshots = len(snapshotLocationsList)
+	for _, snapshot := range snapshotLocationsList {
+		if snapshot.Timestamp > s.newest {
+			s.newest = snapshot.Timestamp
+		}
+	}
+
+	results := make(chan Result)
+	send := func(v Result) {
+		select {
+		case results <- v:
+		case <-ctx.Done():
+		}
+	}
+
+	// If no modules are enabled, the snapshot locations are the results
+	if sc.runner == nil {
+		go func() {
+			defer close(results)
+			defer sc.close()
+			for _, snapshot := range snapshotLocationsList {
+				sc.addSnapshot(snapshot)
+				send(snapshot)
+			}
+		}()
+		return results, nil
+	}
+
+	seenSnapshots := make(map[string]bool)
+	locations := sc.routeSnapshots(snapshotLocationsList, seenSnapshots)
+	s.tracker = progress.NewTracker(len(locations))
+
+	go func() {
+		defer close(results)
+		defer sc.close()
+		sc.process(ctx, locations, seenSnapshots, s.tracker, send)
+		s.oldestFailed = sc.oldestFailed
+	}()
+	return results, nil
+}
+
+// setup creates the modules, deduplication and database of a scan
+func (s *Scanner) setup() (*scan, error) {
+	options := s.options
+	sc := &scan{options: options}
+
+	// Records are added to the database once it's open
+	log := *s.logger
+	log.Record = func(record logger.Record) {
+		s.logger.LogRecord(record)
+		if sc.db != nil {
+			if err := sc.db.AddRecord(record); err != nil {
+				s.logger.Error.Println(err)
+			}
+		}
+	}
+	sc.logger = &log
+	sc.client = wayback.NewClient(options.BaseURL, options.HTTPClient, sc.logger)
+
+	if len(options.Modules) > 0 {
+		instances := []*modules.Instance{}
+		seen := make(map[string]bool)
+		for _, id := range options.Modules {
+			if seen[id] {
+				return nil, fmt.Errorf("module %s is enabled more than once", id)
+			}
+			seen[id] = true
+
+			instance, err := modules.NewInstance(id)
+			if err != nil {
+				return nil, err
+			}
+			instances = append(instances, instance)
+		}
+
+		sc.runner = modules.NewRunner(instances, options.Threads, sc.logger)
+		if err := sc.runner.Init(options.ModuleConfig); err != nil {
+			return nil, err
+		}
+		sc.closers = append(sc.closers, func() {
+			if err := sc.runner.Close(); err != nil {
+				s.logger.Error.Println(err)
+			}
+		})
+	}
+
+	if sc.runner != nil && (options.Deduper != nil || options.Dedup != "") {
+		if options.Timeline {
+			sc.close()
+			return nil, fmt.Errorf("deduplication can't be used with a timeline")
+		}
+		sc.deduper = options.Deduper
+	}
+	if sc.deduper == nil && sc.runner != nil && options.Dedup != "" {
+		var store dedup.Store = dedup.NewMemoryStore()
+		if options.DedupStore != "" {
+			var err error
+			store, err = dedup.NewDiskStore(options.DedupStore)
+			if err != nil {
+				sc.close()
+				return nil, err
+			}
+		}
+		deduper, err := dedup.New(options.Dedup, options.DedupCounts, store)
+		if err != nil {
+			sc.close()
+			return nil, err
+		}
+		sc.deduper = deduper
+		sc.closers = append(sc.closers, func() { deduper.Close() })
+	}
+
+	if options.Database != "" {
+		db, err := database.Open(options.Database, database.Run{
+			Target:  options.Target,
+			Modules: strings.Join(options.Modules, ","),
+			Filters: options.Filters,
+		})
+		if err != nil {
+			sc.close()
+			return nil, err
+		}
+		sc.db = db
+		sc.closers = append(sc.closers, func() {
+			if err := db.Close(); err != nil {
+				s.logger.Error.Println(err)
+			}
+		})
+	}
+
+	return sc, nil
+}
+
+func (sc *scan) addSnapshot(snapshot wayback.Snapshot) {
+	if sc.db != nil {
+		if err := sc.db.AddSnapshot(snapshot); err != nil {
+			sc.logger.Error.Println(err)
+		}
+	}
+}
+
+// process fetches snapshots and passes them to modules, following chained
+// URLs, and sends the results
+func (sc *scan) process(ctx context.Context, locations []wayback.Snapshot, seenSnapshots map[string]bool, tracker *progress.Tracker, send func(Result)) {
+	options := sc.options
+
+	var links *chain.Chain
+	if options.ChainDepth > 0 {
+		links = chain.New(options.Target, options.ChainModules, options.ChainHosts)
+	}
+
+	var history *timeline.Timeline
+	if options.Timeline {
+		history = timeline.New()
+		sc.runner.Handled = func(instance *modules.Instance, snapshot wayback.Snapshot) {
+			history.AddSnapshot(instance.ID, snapshot)
+		}
+	}
+
+	for depth := 0; len(locations) > 0; depth++ {
+		var targets []string
+		fetched := make(map[string]bool)
+		sc.processSnapshots(ctx, locations, tracker, func(snapshot wayback.Snapshot) {
+			fetched[snapshot.SnapshotURL] = true
+			sc.addSnapshot(snapshot)
+		}, func(output modules.ModuleOutput) {
+			tracker.AddResult(output.InstanceID())
+			if links != nil && depth < options.ChainDepth {
+				targets = append(targets, links.Targets(output)...)
+			}
+
+			if sc.db != nil {
+				if err := sc.db.AddOutput(output); err != nil {
+					sc.logger.Error.Println(err)
+				}
+			}
+
+			if history != nil {
+				history.Add(output)
+				return
+			}
+			if sc.deduper != nil {
+				var found bool
+				var err error
+				output, found, err = sc.deduper.Filter(output)
+				if err != nil {
+					sc.logger.Error.Println(err)
+					return
+				}
+				if !found {
+					return
+				}
+			}
+			send(output)
+		})
+		if ctx.Err() != nil {
+			break
+		}
+		for _, location := range locations {
+			if !fetched[location.SnapshotURL] && (sc.oldestFailed == "" || location.Timestamp < sc.oldestFailed) {
+				sc.oldestFailed = location.Timestamp
+			}
+		}
+		if len(targets) == 0 {
+			break
+		}
+
+		sc.logger.Info.Printf("Searching for snapshots of %d chained targets (depth %d)...\n", len(targets), depth+1)
+		locations = sc.routeSnapshots(sc.searchTargets(ctx, targets), seenSnapshots)
+		tracker.AddTotal(len(locations))
+	}
+	if ctx.Err() != nil {
+		return
+	}
+
+	if history != nil {
+		for _, entry := range history.Entries() {
+			send(entry)
+		}
+	}
+
+	if sc.deduper != nil {
+		err := sc.deduper.Records(func(record dedup.Record) error {
+			send(record)
+			return ctx.Err()
+		})
+		if err != nil && ctx.Err() == nil {
+			sc.logger.Error.Println(err)
+		}
+	}
+}
+
+// routeSnapshots returns the snapshots that at least one module handles and
+// that haven't been seen before
+func (sc *scan) routeSnapshots(snapshots []wayback.Snapshot, seen map[string]bool) []wayback.Snapshot {
+	var routed []wayback.Snapshot
+	for _, snapshot := range snapshots {
+		if seen[snapshot.SnapshotURL] {
+			continue
+		}
+		seen[snapshot.SnapshotURL] = true
+		if sc.runner.Accepts(snapshot) {
+			routed = append(routed, snapshot)
+		}
+	}
+	if skipped := len(snapshots) - len(routed); skipped > 0 {
+		sc.logger.Info.Printf("Skipping %d snapshots that are duplicates or not matched by any module\n", skipped)
+	}
+	return routed
+}
+
+// processSnapshots fetches snapshots and passes them to the runner, calling
+// fetched, if it's not nil, for every fetched snapshot and handle for every
+// output. Once ctx is canceled, no more snapshots are fetched.
+func (sc *scan) processSnapshots(ctx context.Context, locations []wayback.Snapshot, tracker *progress.Tracker, fetched func(wayback.Snapshot), handle func(modules.ModuleOutput)) {
+	snapshotLocationsChan := make(chan wayback.Snapshot)
+	snapshotsChan := make(chan wayback.Snapshot)
+
+	var snapshotWg sync.WaitGroup
+	snapshotWg.Add(sc.options.Threads)
+
+	for i := 0; i < sc.options.Threads; i++ {
+		go sc.client.FetchSnapshots(ctx, snapshotLocationsChan, snapshotsChan, &snapshotWg, tracker)
+	}
+
+	go func() {
+		defer close(snapshotLocationsChan)
+		for _, location := range locations {
+			select {
+			case snapshotLocationsChan <- location:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	go func() {
+		snapshotWg.Wait()
+		close(snapshotsChan)
+	}()
+
+	var fetchedChan <-chan wayback.Snapshot = snapshotsChan
+	if fetched != nil {
+		observed := make(chan wayback.Snapshot)
+		go func() {
+			for snapshot := range snapshotsChan {
+				fetched(snapshot)
+				observed <- snapshot
+			}
+			close(observed)
+		}()
+		fetchedChan = observed
+	}
+
+	for output := range sc.runner.Run(ctx, fetchedChan) {
+		handle(output)
+	}
+
+	// The runner stops reading snapshots when ctx is canceled, so the ones
+	// still being fetched are dropped
+	for range fetchedChan {
+	}
+}
+
+// searchTargets searches for snapshots of several targets concurrently.
+// Targets that can't be searched are logged and skipped.
+func (sc *scan) searchTargets(ctx context.Context, targets []string) []wayback.Snapshot {
+	var (
+		mu        sync.Mutex
+		wg        sync.WaitGroup
+		snapshots []wayback.Snapshot
+	)
+	targetsChan := make(chan string)
+
+	for i := 0; i < sc.options.Threads; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for target := range targetsChan {
+				found, err := sc.client.SearchForSnapshots(ctx, target, sc.options.Filters)
+				if err != nil {
+					sc.logger.Info.Println(err)
+					continue
+				}
+				mu.Lock()
+				snapshots = append(snapshots, found...)
+				mu.Unlock()
+			}
+		}()
+	}
+
+	for _, target := range targets {
+		targetsChan <- target
+	}
+	close(targetsChan)
+	wg.Wait()
+
+	return snapshots
+}
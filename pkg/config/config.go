@@ -3,20 +3,47 @@ package config
 import (
 	"flag"
 	"fmt"
+	"time"
 
 	"github.com/mhmdiaa/chronos/v2/pkg/wayback"
 )
 
 type Config struct {
-	Target        string
-	Modules       string
-	ModuleOptions moduleOptions
-	ListModules   bool
-	Filters       wayback.Filters
-	Threads       int
-	ConfigFile    string
-	BaseURL       string
-	OutputFile    string
+	Target         string
+	Modules        string
+	ModuleOptions  moduleOptions
+	Plugins        moduleOptions
+	ListModules    bool
+	Filters        wayback.Filters
+	Threads        int
+	ConfigFile     string
+	BaseURL        string
+	OutputFile     string
+	OutputDir      string
+	NoStdout       bool
+	Format         string
+	OutputDB       string
+	RunInfoFile    string
+	NoProgress     bool
+	ChainDepth     int
+	ChainModules   string
+	ChainHosts     string
+	EmitErrors     bool
+	ErrorsFile     string
+	ProgressEvery  time.Duration
+	Timeline       bool
+	Dedup          string
+	DedupCounts    bool
+	DedupStore     string
+	Watch          time.Duration
+	WatchState     string
+	Notify         moduleOptions
+	NotifyTemplate string
+	NotifyModules  string
+	NotifyLabels   string
+	NotifyBatch    int
+	NotifyInterval time.Duration
+	NotifyRetries  int
 }
 
 type moduleOptions []string
@@ -33,29 +60,123 @@ func (moduleOptions *moduleOptions) Set(value string) error {
 func NewConfig() Config {
 	var c Config
 
-	c.BaseURL = "https://web.archive.org"
+	c.BaseURL = wayback.DefaultBaseURL
 
 	// General options
 	flag.StringVar(&c.Target, "target", "", "Specify the target URL or domain (supports wildcards)")
-	flag.StringVar(&c.Modules, "module", "", "Comma-separated list of modules to run")
-	flag.Var(&c.ModuleOptions, "module-config", "Module configuration in the format: module.key=value")
-	flag.BoolVar(&c.ListModules, "list-modules", false, "List available modules")
+	flag.StringVar(&c.Modules, "module", "", "Comma-separated list of modules to run (use module:name to run a module more than once)")
+	flag.Var(&c.ModuleOptions, "module-config", "Module configuration in the format: module.key=value or module:name.key=value")
+	flag.Var(&c.Plugins, "plugin", "Register an executable as a module in the format: name=command [args...]")
+	flag.BoolVar(&c.ListModules, "list-modules", false, "List available modules and their options")
 	flag.IntVar(&c.Threads, "threads", 10, "Number of concurrent threads to use")
 	flag.StringVar(&c.ConfigFile, "module-config-file", "", "Path to the module configuration file")
 	flag.StringVar(&c.OutputFile, "output", "", "Path to the output file")
+	flag.StringVar(&c.OutputDir, "output-dir", "", "Path to a directory to write the results of each module to, in separate files for every host")
+	flag.BoolVar(&c.NoStdout, "no-stdout", false, "Don't write results to stdout")
+	flag.StringVar(&c.OutputDB, "output-db", "", "Path to a SQLite database to add snapshots, results and errors to")
+	flag.StringVar(&c.RunInfoFile, "run-info", "", "Path to a JSON file to write the run's target, modules, filters and statistics to, for the report command")
+	flag.StringVar(&c.Format, "format", "jsonl", "Output format (possible values: jsonl, json, csv, tsv)")
+	flag.BoolVar(&c.EmitErrors, "emit-errors", false, "Write error and skip records to the output")
+	flag.BoolVar(&c.Timeline, "timeline", false, "Write a timeline of every value found by modules instead of the results of each snapshot")
+	flag.StringVar(&c.Dedup, "dedup", "", "Only write values that weren't found before, comparing them by key (possible values: value, value-url, value-module)")
+	flag.BoolVar(&c.DedupCounts, "dedup-counts", false, "Write every deduplicated value once at the end, with its number of occurrences and first and last timestamps")
+	flag.StringVar(&c.DedupStore, "dedup-store", "", "Path to an on-disk store for deduplicated values, for runs with too many values to keep in memory")
+	flag.StringVar(&c.ErrorsFile, "errors-output", "", "Path to a separate file for error and skip records")
+	flag.BoolVar(&c.NoProgress, "no-progress", false, "Disable progress reporting")
+	flag.DurationVar(&c.ProgressEvery, "progress-interval", 10*time.Second, "Interval between progress log lines when stderr is not a terminal")
+
+	// Chaining options
+	flag.IntVar(&c.ChainDepth, "chain-depth", 0, "Search for snapshots of URLs found by modules, up to this many levels deep")
+	flag.StringVar(&c.ChainModules, "chain-modules", "", "Comma-separated list of modules whose results are chained (default: all modules)")
+	flag.StringVar(&c.ChainHosts, "chain-hosts", "", "Comma-separated list of hosts chained URLs may point to, *.example.com matches subdomains (default: the target's host and subdomains)")
+
+	// Watch options
+	flag.DurationVar(&c.Watch, "watch", 0, "Poll for new snapshots of the targets at this interval, running modules on them only and writing only new values (-target may be a comma-separated list)")
+	flag.StringVar(&c.WatchState, "watch-state", "", "Path to a JSON file to keep the newest snapshot seen of every target and the values already written in, between polls and restarts")
+
+	// Notification options
+	flag.Var(&c.Notify, "notify", "Send findings to a webhook in the format: format=url, where format is webhook, slack or discord")
+	flag.StringVar(&c.NotifyTemplate, "notify-template", "", "Path to a Go text/template of the JSON payload of webhook notifications, executed with the findings as .Findings")
+	flag.StringVar(&c.NotifyModules, "notify-modules", "", "Comma-separated list of modules whose findings are sent (default: all modules)")
+	flag.StringVar(&c.NotifyLabels, "notify-labels", "", "Comma-separated list of labels of the findings that are sent (default: all labels)")
+	flag.IntVar(&c.NotifyBatch, "notify-batch", 20, "Number of findings to send in one notification at most")
+	flag.DurationVar(&c.NotifyInterval, "notify-interval", 10*time.Second, "Longest time a finding waits for more findings before it's sent")
+	flag.IntVar(&c.NotifyRetries, "notify-retries", 3, "Number of times to retry sending a notification that failed")
 
 	// Filter options
-	flag.StringVar(&c.Filters.From, "from", "", "Filter snapshots from a specific date (Format: yyyyMMddhhmmss)")
-	flag.StringVar(&c.Filters.To, "to", "", "Filter snapshots to a specific date (Format: yyyyMMddhhmmss)")
-	flag.StringVar(&c.Filters.StatusMatchList, "match-status", "200", "Comma-separated list of status codes to match")
-	flag.StringVar(&c.Filters.StatusFilterList, "filter-status", "", "Comma-separated list of status codes to filter out")
-	flag.StringVar(&c.Filters.MimeMatchList, "match-mime", "", "Comma-separated list of MIME types to match")
-	flag.StringVar(&c.Filters.MimeFilterList, "filter-mime", "", "Comma-separated list of MIME types to filter out")
-	flag.StringVar(&c.Filters.Limit, "limit", "-50", "Limit the number of snapshots to process (use negative numbers for the newest N snapshots, positive numbers for the oldest N results)")
-	flag.StringVar(&c.Filters.Interval, "snapshot-interval", "", "The interval for getting at most one snapshot (possible values: h, d, m, y)")
-	flag.BoolVar(&c.Filters.OnePerURL, "one-per-url", false, "Fetch one snapshot only per URL")
+	addFilterFlags(flag.CommandLine, &c.Filters)
 
 	flag.Parse()
 
 	return c
 }
+
+func addFilterFlags(flags *flag.FlagSet, filters *wayback.Filters) {
+	flags.StringVar(&filters.From, "from", "", "Filter snapshots from a specific date (Format: yyyyMMddhhmmss)")
+	flags.StringVar(&filters.To, "to", "", "Filter snapshots to a specific date (Format: yyyyMMddhhmmss)")
+	flags.StringVar(&filters.StatusMatchList, "match-status", "200", "Comma-separated list of status codes to match")
+	flags.StringVar(&filters.StatusFilterList, "filter-status", "", "Comma-separated list of status codes to filter out")
+	flags.StringVar(&filters.MimeMatchList, "match-mime", "", "Comma-separated list of MIME types to match")
+	flags.StringVar(&filters.MimeFilterList, "filter-mime", "", "Comma-separated list of MIME types to filter out")
+	flags.StringVar(&filters.Limit, "limit", "-50", "Limit the number of snapshots to process (use negative numbers for the newest N snapshots, positive numbers for the oldest N results)")
+	flags.StringVar(&filters.Interval, "snapshot-interval", "", "The interval for getting at most one snapshot (possible values: h, d, m, y)")
+	flags.BoolVar(&filters.OnePerURL, "one-per-url", false, "Fetch one snapshot only per URL")
+	flags.StringVar(&filters.At, "at", "", "Fetch the snapshot of every URL closest to a date, ignoring -limit, -snapshot-interval and -one-per-url (Format: yyyyMMdd[hhmmss])")
+	flags.StringVar(&filters.Tolerance, "at-tolerance", "1y", "How far from the -at date snapshots may be, as a number of hours, days, months or years, like 6m (empty for no limit, which wildcard targets don't allow)")
+}
+
+type ReportConfig struct {
+	Inputs      []string
+	RunInfoFile string
+	OutputFile  string
+	Title       string
+}
+
+// NewReportConfig parses the arguments of the report command
+func NewReportConfig(args []string) ReportConfig {
+	var c ReportConfig
+
+	flags := flag.NewFlagSet("report", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: chronos report [options] output.json [errors.json...]\n")
+		flags.PrintDefaults()
+	}
+	flags.StringVar(&c.RunInfoFile, "run-info", "", "Path to the run info file written with -run-info")
+	flags.StringVar(&c.OutputFile, "output", "report.html", "Path to the HTML report")
+	flags.StringVar(&c.Title, "title", "Chronos report", "Title of the report")
+	flags.Parse(args)
+
+	c.Inputs = flags.Args()
+	return c
+}
+
+type ServeConfig struct {
+	Listen      string
+	MaxScans    int
+	MaxFinished int
+	Threads     int
+	Filters     wayback.Filters
+	BaseURL     string
+}
+
+// NewServeConfig parses the arguments of the serve command. The filter
+// options are the defaults of submitted scans.
+func NewServeConfig(args []string) ServeConfig {
+	var c ServeConfig
+
+	c.BaseURL = wayback.DefaultBaseURL
+
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: chronos serve [options]\n")
+		flags.PrintDefaults()
+	}
+	flags.StringVar(&c.Listen, "listen", "127.0.0.1:8080", "Address to listen on")
+	flags.IntVar(&c.MaxScans, "max-scans", 2, "Number of scans to run at the same time, others are queued")
+	flags.IntVar(&c.MaxFinished, "max-finished", 100, "Number of finished scans to keep the results of, older ones are removed")
+	flags.IntVar(&c.Threads, "threads", 10, "Number of concurrent threads each scan uses by default, and at most")
+	addFilterFlags(flags, &c.Filters)
+	flags.Parse(args)
+
+	return c
+}
@@ -0,0 +1,218 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/mhmdiaa/chronos/v2/modules"
+	"github.com/mhmdiaa/chronos/v2/pkg/logger"
+	"github.com/mhmdiaa/chronos/v2/pkg/wayback"
+)
+
+// Snapshots and results are unique, so running chronos again with the same
+// database updates the run that last saw them instead of adding duplicates
+const schema = `
+CREATE TABLE IF NOT EXISTS runs (
+	id          INTEGER PRIMARY KEY,
+	target      TEXT NOT NULL,
+	modules     TEXT NOT NULL,
+	filters     TEXT NOT NULL,
+	started_at  TEXT NOT NULL,
+	finished_at TEXT
+);
+
+CREATE TABLE IF NOT EXISTS snapshots (
+	id           INTEGER PRIMARY KEY,
+	url          TEXT NOT NULL,
+	host         TEXT NOT NULL,
+	timestamp    TEXT NOT NULL,
+	snapshot_url TEXT NOT NULL UNIQUE,
+	mime         TEXT,
+	status       TEXT,
+	digest       TEXT,
+	length       TEXT,
+	first_run_id INTEGER NOT NULL REFERENCES runs(id),
+	last_run_id  INTEGER NOT NULL REFERENCES runs(id)
+);
+CREATE INDEX IF NOT EXISTS snapshots_host ON snapshots(host);
+CREATE INDEX IF NOT EXISTS snapshots_url ON snapshots(url, timestamp);
+
+CREATE TABLE IF NOT EXISTS results (
+	id           INTEGER PRIMARY KEY,
+	snapshot_id  INTEGER NOT NULL REFERENCES snapshots(id),
+	module       TEXT NOT NULL,
+	instance     TEXT NOT NULL,
+	label        TEXT NOT NULL,
+	value        TEXT NOT NULL,
+	first_run_id INTEGER NOT NULL REFERENCES runs(id),
+	last_run_id  INTEGER NOT NULL REFERENCES runs(id),
+	UNIQUE (snapshot_id, module, instance, label, value)
+);
+CREATE INDEX IF NOT EXISTS results_module ON results(module, label);
+
+CREATE TABLE IF NOT EXISTS errors (
+	id           INTEGER PRIMARY KEY,
+	run_id       INTEGER NOT NULL REFERENCES runs(id),
+	type         TEXT NOT NULL,
+	module       TEXT NOT NULL,
+	url          TEXT NOT NULL,
+	snapshot_url TEXT NOT NULL,
+	stage        TEXT NOT NULL,
+	class        TEXT NOT NULL,
+	message      TEXT NOT NULL,
+	UNIQUE (snapshot_url, module, stage)
+);
+`
+
+// Run describes a run of chronos
+type Run struct {
+	Target  string
+	Modules string
+	Filters wayback.Filters
+}
+
+// DB writes snapshots, module results and error records of a run to a
+// SQLite database. It's safe for concurrent use.
+type DB struct {
+	mu    sync.Mutex
+	db    *sql.DB
+	runID int64
+}
+
+// Open opens or creates the database at path and records a new run
+func Open(path string, run Run) (*DB, error) {
+	db, err := sql.Open("sqlite3", path+"?_journal_mode=WAL&_synchronous=NORMAL&_busy_timeout=5000&_foreign_keys=on")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open the database %s: %v", path, err)
+	}
+	db.SetMaxOpenConns(1)
+
+	if _, err := db.Exec(schema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create the database schema in %s: %v", path, err)
+	}
+
+	result, err := db.Exec(
+		"INSERT INTO runs (target, modules, filters, started_at) VALUES (?, ?, ?, ?)",
+		run.Target, run.Modules, strings.Join(run.Filters.Options(), " "), now(),
+	)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	runID, err := result.LastInsertId()
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &DB{db: db, runID: runID}, nil
+}
+
+func now() string {
+	return time.Now().UTC().Format(time.RFC3339)
+}
+
+// AddSnapshot records a fetched snapshot
+func (d *DB) AddSnapshot(snapshot wayback.Snapshot) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	_, err := d.upsertSnapshot(d.db, snapshot)
+	return err
+}
+
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+func (d *DB) upsertSnapshot(tx execer, snapshot wayback.Snapshot) (int64, error) {
+	_, err := tx.Exec(`
+		INSERT INTO snapshots (url, host, timestamp, snapshot_url, mime, status, digest, length, first_run_id, last_run_id)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (snapshot_url) DO UPDATE SET
+			mime = coalesce(nullif(excluded.mime, ''), mime),
+			status = coalesce(nullif(excluded.status, ''), status),
+			digest = coalesce(nullif(excluded.digest, ''), digest),
+			length = coalesce(nullif(excluded.length, ''), length),
+			last_run_id = excluded.last_run_id`,
+		snapshot.OriginalURL, wayback.Host(snapshot.OriginalURL), snapshot.Timestamp, snapshot.SnapshotURL,
+		snapshot.MimeType, snapshot.StatusCode, snapshot.Digest, snapshot.Length, d.runID, d.runID,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to save snapshot %s: %v", snapshot.SnapshotURL, err)
+	}
+
+	var id int64
+	err = tx.QueryRow("SELECT id FROM snapshots WHERE snapshot_url = ?", snapshot.SnapshotURL).Scan(&id)
+	return id, err
+}
+
+// AddOutput records every value in a module output
+func (d *DB) AddOutput(output modules.ModuleOutput) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	snapshotID, err := d.upsertSnapshot(tx, wayback.Snapshot{
+		OriginalURL: output.URL,
+		SnapshotURL: output.SnapshotURL,
+		Timestamp:   output.Timestamp,
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, result := range output.Results {
+		for _, value := range result.Values() {
+			_, err := tx.Exec(`
+				INSERT INTO results (snapshot_id, module, instance, label, value, first_run_id, last_run_id)
+				VALUES (?, ?, ?, ?, ?, ?, ?)
+				ON CONFLICT (snapshot_id, module, instance, label, value) DO UPDATE SET
+					last_run_id = excluded.last_run_id`,
+				snapshotID, output.Module, output.Instance, result.Label, modules.FormatValue(value), d.runID, d.runID,
+			)
+			if err != nil {
+				return fmt.Errorf("failed to save results of %s: %v", output.SnapshotURL, err)
+			}
+		}
+	}
+	return tx.Commit()
+}
+
+// AddRecord records an error or skipped snapshot
+func (d *DB) AddRecord(record logger.Record) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	_, err := d.db.Exec(`
+		INSERT INTO errors (run_id, type, module, url, snapshot_url, stage, class, message)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (snapshot_url, module, stage) DO UPDATE SET
+			run_id = excluded.run_id,
+			type = excluded.type,
+			class = excluded.class,
+			message = excluded.message`,
+		d.runID, record.Type, record.Module, record.URL, record.SnapshotURL, record.Stage, record.Class, record.Message,
+	)
+	return err
+}
+
+// Close marks the run as finished and closes the database
+func (d *DB) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	_, err := d.db.Exec("UPDATE runs SET finished_at = ? WHERE id = ?", now(), d.runID)
+	if closeErr := d.db.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
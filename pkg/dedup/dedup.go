@@ -0,0 +1,167 @@
+package dedup
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/mhmdiaa/chronos/v2/modules"
+)
+
+// Keys decide which values are duplicates of each other
+const (
+	KeyValue       = "value"
+	KeyValueURL    = "value-url"
+	KeyValueModule = "value-module"
+)
+
+// Record counts the occurrences of a value. The module, URL and label are
+// those of the first occurrence.
+type Record struct {
+	Module    string      `json:"module"`
+	Instance  string      `json:"instance,omitempty"`
+	URL       string      `json:"url"`
+	Label     string      `json:"label,omitempty"`
+	Value     interface{} `json:"value"`
+	Count     int         `json:"count"`
+	FirstSeen string      `json:"first_seen"`
+	LastSeen  string      `json:"last_seen"`
+}
+
+func (record Record) Columns() []string {
+	return []string{"module", "url", "label", "value", "count", "first_seen", "last_seen"}
+}
+
+func (record Record) Rows() [][]string {
+	return [][]string{{record.module(), record.URL, record.Label, modules.FormatValue(record.Value), strconv.Itoa(record.Count), record.FirstSeen, record.LastSeen}}
+}
+
+func (record Record) Group() (string, string) {
+	return record.URL, record.module()
+}
+
+func (record Record) module() string {
+	if record.Instance == "" {
+		return record.Module
+	}
+	return record.Module + ":" + record.Instance
+}
+
+// Store keeps the values seen so far
+type Store interface {
+	// Update calls update with the record stored under key, or nil if there's
+	// none, and stores the record it returns
+	Update(key []byte, update func(record *Record) *Record) error
+	// Each calls fn for every stored record
+	Each(fn func(record Record) error) error
+	Close() error
+}
+
+// Deduper removes values that were already seen from module outputs
+type Deduper struct {
+	key    string
+	counts bool
+	store  Store
+}
+
+// New creates a deduper comparing values by key. If counts is true, values
+// are counted instead of being written as they're found.
+func New(key string, counts bool, store Store) (*Deduper, error) {
+	if key != KeyValue && key != KeyValueURL && key != KeyValueModule {
+		return nil, fmt.Errorf("unknown deduplication key %s", key)
+	}
+	return &Deduper{
+		key:    key,
+		counts: counts,
+		store:  store,
+	}, nil
+}
+
+// Filter returns the output without the values that were seen before, and
+// whether any values are left. When counting, no values are ever left.
+func (d *Deduper) Filter(output modules.ModuleOutput) (modules.ModuleOutput, bool, error) {
+	var results modules.Results
+	for _, result := range output.Results {
+		all := result.Values()
+		var values []interface{}
+		for _, value := range all {
+			isNew, err := d.add(output, result.Label, value)
+			if err != nil {
+				return output, false, err
+			}
+			if isNew && !d.counts {
+				values = append(values, value)
+			}
+		}
+
+		switch {
+		case len(values) == 0:
+		case len(values) == len(all):
+			results = append(results, result)
+		case len(values) > 0:
+			results = append(results, modules.Result{Label: result.Label, Value: values})
+		}
+	}
+
+	output.Results = results
+	return output, len(results) > 0, nil
+}
+
+func (d *Deduper) add(output modules.ModuleOutput, label string, value interface{}) (bool, error) {
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return false, err
+	}
+
+	hash := sha256.New()
+	switch d.key {
+	case KeyValueURL:
+		hash.Write([]byte(output.URL + "\x00"))
+	case KeyValueModule:
+		hash.Write([]byte(output.InstanceID() + "\x00"))
+	}
+	hash.Write(encoded)
+
+	isNew := false
+	err = d.store.Update(hash.Sum(nil), func(record *Record) *Record {
+		if record == nil {
+			isNew = true
+			if !d.counts {
+				return &Record{}
+			}
+			record = &Record{
+				Module:    output.Module,
+				Instance:  output.Instance,
+				URL:       output.URL,
+				Label:     label,
+				Value:     value,
+				FirstSeen: output.Timestamp,
+				LastSeen:  output.Timestamp,
+			}
+		}
+		if d.counts {
+			record.Count++
+			if output.Timestamp < record.FirstSeen {
+				record.FirstSeen = output.Timestamp
+			}
+			if output.Timestamp > record.LastSeen {
+				record.LastSeen = output.Timestamp
+			}
+		}
+		return record
+	})
+	return isNew, err
+}
+
+// Records calls fn for the record of every value when counting
+func (d *Deduper) Records(fn func(record Record) error) error {
+	if !d.counts {
+		return nil
+	}
+	return d.store.Each(fn)
+}
+
+func (d *Deduper) Close() error {
+	return d.store.Close()
+}
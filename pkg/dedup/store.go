@@ -0,0 +1,121 @@
+package dedup
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// MemoryStore keeps records in memory
+type MemoryStore struct {
+	mu      sync.Mutex
+	records map[string]*Record
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		records: make(map[string]*Record),
+	}
+}
+
+func (s *MemoryStore) Update(key []byte, update func(record *Record) *Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.records[string(key)] = update(s.records[string(key)])
+	return nil
+}
+
+// Each calls fn for every record in the order of their keys, like DiskStore
+func (s *MemoryStore) Each(fn func(record Record) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := make([]string, 0, len(s.records))
+	for key := range s.records {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if err := fn(*s.records[key]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) Close() error {
+	return nil
+}
+
+var bucket = []byte("values")
+
+// DiskStore keeps records in a bbolt database, for runs with more values
+// than fit in memory. The records of earlier runs are removed when it's
+// opened.
+type DiskStore struct {
+	db *bolt.DB
+}
+
+// NewDiskStore opens or creates the database at path. Existing files that
+// aren't bbolt databases are left untouched and return an error.
+func NewDiskStore(path string) (*DiskStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open the deduplication store %s: %v", path, err)
+	}
+	// The store is rebuilt on every run, so it doesn't need to survive crashes
+	db.NoSync = true
+	db.NoFreelistSync = true
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket(bucket) != nil {
+			if err := tx.DeleteBucket(bucket); err != nil {
+				return err
+			}
+		}
+		_, err := tx.CreateBucket(bucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &DiskStore{db: db}, nil
+}
+
+func (s *DiskStore) Update(key []byte, update func(record *Record) *Record) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucket)
+		var record *Record
+		if data := b.Get(key); data != nil {
+			record = &Record{}
+			if err := json.Unmarshal(data, record); err != nil {
+				return err
+			}
+		}
+		data, err := json.Marshal(update(record))
+		if err != nil {
+			return err
+		}
+		return b.Put(key, data)
+	})
+}
+
+func (s *DiskStore) Each(fn func(record Record) error) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucket).ForEach(func(_, data []byte) error {
+			var record Record
+			if err := json.Unmarshal(data, &record); err != nil {
+				return err
+			}
+			return fn(record)
+		})
+	})
+}
+
+func (s *DiskStore) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,207 @@
+package diff
+
+import (
+	"fmt"
+	"strings"
+)
+
+type Op int
+
+const (
+	Equal Op = iota
+	Insert
+	Delete
+)
+
+type Edit struct {
+	Op   Op
+	Line string
+}
+
+// Diffs with more edits than this are reported as replacing all the lines
+// that differ, to bound the memory used by the search
+const maxEdits = 2000
+
+// Lines returns the edits turning a into b, using Myers' algorithm
+func Lines(a, b []string) []Edit {
+	var prefix, suffix []Edit
+	for len(a) > 0 && len(b) > 0 && a[0] == b[0] {
+		prefix = append(prefix, Edit{Equal, a[0]})
+		a, b = a[1:], b[1:]
+	}
+	for len(a) > 0 && len(b) > 0 && a[len(a)-1] == b[len(b)-1] {
+		suffix = append(suffix, Edit{Equal, a[len(a)-1]})
+		a, b = a[:len(a)-1], b[:len(b)-1]
+	}
+
+	edits := append(prefix, myers(a, b)...)
+	for i := len(suffix) - 1; i >= 0; i-- {
+		edits = append(edits, suffix[i])
+	}
+	return edits
+}
+
+func myers(a, b []string) []Edit {
+	n, m := len(a), len(b)
+	if n == 0 && m == 0 {
+		return nil
+	}
+
+	max := n + m
+	offset := max + 1
+	v := make([]int, 2*max+3)
+	// trace[d] holds v[-d+1..d-1] as it was before round d
+	var trace [][]int
+
+	for d := 0; d <= max && d <= maxEdits; d++ {
+		round := make([]int, 0, 2*d)
+		if d > 0 {
+			round = append(round, v[offset-d+1:offset+d]...)
+		}
+		trace = append(trace, round)
+
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				return backtrack(trace, a, b)
+			}
+		}
+	}
+
+	edits := make([]Edit, 0, n+m)
+	for _, line := range a {
+		edits = append(edits, Edit{Delete, line})
+	}
+	for _, line := range b {
+		edits = append(edits, Edit{Insert, line})
+	}
+	return edits
+}
+
+func backtrack(trace [][]int, a, b []string) []Edit {
+	var edits []Edit
+	x, y := len(a), len(b)
+	for d := len(trace) - 1; d >= 0; d-- {
+		prevX, prevY := 0, 0
+		if d > 0 {
+			at := func(k int) int { return trace[d][k+d-1] }
+			k := x - y
+			prevK := k - 1
+			if k == -d || (k != d && at(k-1) < at(k+1)) {
+				prevK = k + 1
+			}
+			prevX = at(prevK)
+			prevY = prevX - prevK
+		}
+
+		for x > prevX && y > prevY {
+			edits = append(edits, Edit{Equal, a[x-1]})
+			x--
+			y--
+		}
+		if d > 0 {
+			if x == prevX {
+				edits = append(edits, Edit{Insert, b[y-1]})
+			} else {
+				edits = append(edits, Edit{Delete, a[x-1]})
+			}
+		}
+		x, y = prevX, prevY
+	}
+
+	for i, j := 0, len(edits)-1; i < j; i, j = i+1, j-1 {
+		edits[i], edits[j] = edits[j], edits[i]
+	}
+	return edits
+}
+
+// Unified formats edits as a unified diff with the given number of context
+// lines around every change. A negative context is treated as 0.
+func Unified(from, to string, edits []Edit, context int) string {
+	if context < 0 {
+		context = 0
+	}
+
+	// Line numbers of a and b before each edit
+	aLines := make([]int, len(edits)+1)
+	bLines := make([]int, len(edits)+1)
+	for i, edit := range edits {
+		aLines[i+1], bLines[i+1] = aLines[i], bLines[i]
+		if edit.Op != Insert {
+			aLines[i+1]++
+		}
+		if edit.Op != Delete {
+			bLines[i+1]++
+		}
+	}
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "--- %s\n+++ %s\n", from, to)
+
+	for i := 0; i < len(edits); {
+		change := i
+		for change < len(edits) && edits[change].Op == Equal {
+			change++
+		}
+		if change == len(edits) {
+			break
+		}
+
+		start := change - context
+		if start < i {
+			start = i
+		}
+		last := change
+		end := change
+		for ; end < len(edits); end++ {
+			if edits[end].Op != Equal {
+				last = end
+			} else if end-last > 2*context {
+				break
+			}
+		}
+		end = last + context + 1
+		if end > len(edits) {
+			end = len(edits)
+		}
+
+		fmt.Fprintf(&out, "@@ -%s +%s @@\n",
+			hunkRange(aLines[start], aLines[end]-aLines[start]),
+			hunkRange(bLines[start], bLines[end]-bLines[start]))
+		for _, edit := range edits[start:end] {
+			switch edit.Op {
+			case Equal:
+				out.WriteString(" ")
+			case Insert:
+				out.WriteString("+")
+			case Delete:
+				out.WriteString("-")
+			}
+			out.WriteString(edit.Line)
+			out.WriteString("\n")
+		}
+		i = end
+	}
+	return out.String()
+}
+
+func hunkRange(start, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", start)
+	}
+	if count == 1 {
+		return fmt.Sprintf("%d", start+1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, count)
+}
@@ -0,0 +1,159 @@
+package diff
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func lines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, " ")
+}
+
+func TestLines(t *testing.T) {
+	tests := []struct {
+		name    string
+		a, b    string
+		changes int
+	}{
+		{"equal", "a b c", "a b c", 0},
+		{"both empty", "", "", 0},
+		{"from empty", "", "a b", 2},
+		{"to empty", "a b", "", 2},
+		{"insert", "a c", "a b c", 1},
+		{"delete", "a b c", "a c", 1},
+		{"replace", "a b c", "a x c", 2},
+		{"prefix and suffix", "x a b c", "a b c y", 2},
+		{"move", "a b c d", "b c d a", 2},
+		{"repeated lines", "a a a b", "a b a a", 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, b := lines(tt.a), lines(tt.b)
+			edits := Lines(a, b)
+
+			var gotA, gotB []string
+			changes := 0
+			for _, edit := range edits {
+				if edit.Op != Insert {
+					gotA = append(gotA, edit.Line)
+				}
+				if edit.Op != Delete {
+					gotB = append(gotB, edit.Line)
+				}
+				if edit.Op != Equal {
+					changes++
+				}
+			}
+			if !reflect.DeepEqual(gotA, a) || !reflect.DeepEqual(gotB, b) {
+				t.Errorf("edits %v don't turn %q into %q", edits, tt.a, tt.b)
+			}
+			if changes != tt.changes {
+				t.Errorf("got %d changes, want %d", changes, tt.changes)
+			}
+		})
+	}
+}
+
+func TestUnified(t *testing.T) {
+	tests := []struct {
+		name    string
+		a, b    string
+		context int
+		want    string
+	}{
+		{
+			name: "no changes",
+			a:    "1 2 3",
+			b:    "1 2 3",
+			want: "",
+		},
+		{
+			name: "from empty",
+			a:    "",
+			b:    "x",
+			want: "@@ -0,0 +1 @@\n+x\n",
+		},
+		{
+			name: "to empty",
+			a:    "x",
+			b:    "",
+			want: "@@ -1 +0,0 @@\n-x\n",
+		},
+		{
+			name:    "context",
+			a:       "1 2 3 4 5 6 7 8 9 10",
+			b:       "1 2 3 4 x 6 7 8 9 10",
+			context: 2,
+			want:    "@@ -3,5 +3,5 @@\n 3\n 4\n-5\n+x\n 6\n 7\n",
+		},
+		{
+			name:    "no context",
+			a:       "1 2 3",
+			b:       "1 x 3",
+			context: 0,
+			want:    "@@ -2 +2 @@\n-2\n+x\n",
+		},
+		{
+			name:    "negative context",
+			a:       "1 2 3",
+			b:       "1 x 3",
+			context: -1,
+			want:    "@@ -2 +2 @@\n-2\n+x\n",
+		},
+		{
+			name:    "separate hunks",
+			a:       "1 2 3 4 5 6 7 8 9 10",
+			b:       "1 x 3 4 5 6 7 8 y 10",
+			context: 1,
+			want:    "@@ -1,3 +1,3 @@\n 1\n-2\n+x\n 3\n@@ -8,3 +8,3 @@\n 8\n-9\n+y\n 10\n",
+		},
+		{
+			name:    "merged hunks",
+			a:       "1 2 3 4 5 6",
+			b:       "1 x 3 y 5 6",
+			context: 1,
+			want:    "@@ -1,5 +1,5 @@\n 1\n-2\n+x\n 3\n-4\n+y\n 5\n",
+		},
+		{
+			name:    "insert at the end",
+			a:       "1 2 3",
+			b:       "1 2 3 4",
+			context: 1,
+			want:    "@@ -3 +3,2 @@\n 3\n+4\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Unified("a", "b", Lines(lines(tt.a), lines(tt.b)), tt.context)
+			want := "--- a\n+++ b\n" + tt.want
+			if got != want {
+				t.Errorf("got:\n%s\nwant:\n%s", got, want)
+			}
+		})
+	}
+}
+
+func TestLinesTooManyEdits(t *testing.T) {
+	var a, b []string
+	for i := 0; i < maxEdits+1; i++ {
+		a = append(a, "a")
+		b = append(b, "b")
+	}
+	edits := Lines(a, b)
+	if len(edits) != len(a)+len(b) {
+		t.Fatalf("got %d edits, want %d", len(edits), len(a)+len(b))
+	}
+	for i, edit := range edits {
+		want := Delete
+		if i >= len(a) {
+			want = Insert
+		}
+		if edit.Op != want {
+			t.Fatalf("edit %d is %v, want %v", i, edit.Op, want)
+		}
+	}
+}
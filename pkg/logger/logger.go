@@ -1,42 +1,172 @@
 package logger
 
 import (
+	"encoding/json"
 	"fmt"
 	"io"
 	"log"
 	"os"
+	"sync"
 )
 
 var (
-	file *os.File
+	file        *os.File
+	recordsFile *os.File
+	records     *log.Logger
 
-	Output *log.Logger
-	Info   *log.Logger
-	Warn   *log.Logger
-	Error  *log.Logger
+	handlersMu sync.Mutex
+	handlers   []recordHandler
+	nextID     int
+
+	Output = log.New(os.Stdout, "", 0)
+	Info   = log.New(os.Stderr, "[INFO] ", 0)
+	Warn   = log.New(os.Stderr, "[WARN] ", 0)
+	Error  = log.New(os.Stderr, "[ERROR] ", 0)
+)
+
+// Logger writes the messages and the error and skip records of a run
+type Logger struct {
+	Info  *log.Logger
+	Warn  *log.Logger
+	Error *log.Logger
+	// Record, if not nil, is called with every error and skip record
+	Record func(Record)
+}
+
+// Default returns a logger writing to the global loggers and records
+func Default() *Logger {
+	return &Logger{Info: Info, Warn: Warn, Error: Error, Record: LogRecord}
+}
+
+// Discard returns a logger discarding messages and records
+func Discard() *Logger {
+	discard := log.New(io.Discard, "", 0)
+	return &Logger{Info: discard, Warn: discard, Error: discard}
+}
+
+func (l *Logger) LogRecord(record Record) {
+	if l.Record != nil {
+		l.Record(record)
+	}
+}
+
+// Record is a machine-readable description of a snapshot that failed or was
+// skipped at some stage of processing
+type Record struct {
+	Type        string `json:"type"`
+	Module      string `json:"module,omitempty"`
+	URL         string `json:"url,omitempty"`
+	SnapshotURL string `json:"snapshot,omitempty"`
+	Stage       string `json:"stage"`
+	Class       string `json:"class"`
+	Message     string `json:"message"`
+}
+
+func (record Record) Group() (string, string) {
+	return record.URL, "errors"
+}
+
+const (
+	RecordError = "error"
+	RecordSkip  = "skip"
 )
 
-func Init(outputFile string) error {
+// Init sets where the output is written: to outputFile if it's not empty,
+// and to stdout if toStdout is set. outputFile is appended to if appendFile
+// is set, and overwritten otherwise.
+func Init(outputFile string, appendFile, toStdout bool) error {
+	var writers []io.Writer
+	if toStdout {
+		writers = append(writers, os.Stdout)
+	}
 	if outputFile != "" {
-		file, err := os.OpenFile(outputFile, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
+		var err error
+		flags := os.O_RDWR | os.O_CREATE | os.O_TRUNC
+		if appendFile {
+			flags = os.O_RDWR | os.O_CREATE | os.O_APPEND
+		}
+		file, err = os.OpenFile(outputFile, flags, 0666)
 		if err != nil {
 			return fmt.Errorf("error opening file: %v", err)
 		}
-		writer := io.MultiWriter(os.Stdout, file)
-		Output = log.New(writer, "", 0)
-	} else {
-		Output = log.New(os.Stdout, "", 0)
+		writers = append(writers, file)
 	}
+	Output.SetOutput(io.MultiWriter(writers...))
+	return nil
+}
 
-	Info = log.New(os.Stderr, "[INFO] ", 0)
-	Warn = log.New(os.Stderr, "[WARN] ", 0)
-	Error = log.New(os.Stderr, "[ERROR] ", 0)
-
+// InitRecords enables writing error and skip records to errorsFile, or
+// passing them to output if errorsFile is empty and output isn't nil
+func InitRecords(output func(Record), errorsFile string) error {
+	if errorsFile != "" {
+		var err error
+		recordsFile, err = os.OpenFile(errorsFile, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
+		if err != nil {
+			return fmt.Errorf("error opening file: %v", err)
+		}
+		records = log.New(recordsFile, "", 0)
+	} else if output != nil {
+		OnRecord(output)
+	}
 	return nil
 }
 
+type recordHandler struct {
+	id     int
+	handle func(Record)
+}
+
+// OnRecord adds a function called with every error and skip record, and
+// returns a function removing it
+func OnRecord(handler func(Record)) func() {
+	handlersMu.Lock()
+	defer handlersMu.Unlock()
+	id := nextID
+	nextID++
+	handlers = append(handlers, recordHandler{id: id, handle: handler})
+	return func() {
+		handlersMu.Lock()
+		defer handlersMu.Unlock()
+		for i, h := range handlers {
+			if h.id == id {
+				handlers = append(handlers[:i:i], handlers[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// LogRecord writes record if error and skip records are enabled
+func LogRecord(record Record) {
+	handlersMu.Lock()
+	current := handlers
+	handlersMu.Unlock()
+	for _, h := range current {
+		h.handle(record)
+	}
+	if records == nil {
+		return
+	}
+	j, err := json.Marshal(record)
+	if err != nil {
+		Error.Println(err)
+		return
+	}
+	records.Println(string(j))
+}
+
+// SetStderr redirects the info, warning and error loggers to w
+func SetStderr(w io.Writer) {
+	Info.SetOutput(w)
+	Warn.SetOutput(w)
+	Error.SetOutput(w)
+}
+
 func Close() {
 	if file != nil {
 		file.Close()
 	}
+	if recordsFile != nil {
+		recordsFile.Close()
+	}
 }
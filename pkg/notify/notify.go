@@ -0,0 +1,352 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"text/template"
+	"time"
+	"unicode/utf8"
+
+	"github.com/mhmdiaa/chronos/v2/modules"
+	"github.com/mhmdiaa/chronos/v2/pkg/logger"
+)
+
+// Formats of notifications
+const (
+	FormatWebhook = "webhook"
+	FormatSlack   = "slack"
+	FormatDiscord = "discord"
+)
+
+// Messages longer than these are cut, listing how many findings were left
+// out
+const (
+	slackLimit   = 4000
+	discordLimit = 2000
+	valueLimit   = 200
+)
+
+const (
+	// queueSize is the most findings waiting to be sent. Findings are dropped
+	// when the queue is full.
+	queueSize = 1000
+	// timeout is the timeout of requests of the default HTTP client
+	timeout = 30 * time.Second
+)
+
+// retryDelay is the delay before the first retry of a failed message, which
+// doubles on every retry
+var retryDelay = time.Second
+
+// Finding is a value found by a module in a snapshot
+type Finding struct {
+	Module    string      `json:"module"`
+	URL       string      `json:"url"`
+	Snapshot  string      `json:"snapshot"`
+	Timestamp string      `json:"timestamp"`
+	Label     string      `json:"label,omitempty"`
+	Value     interface{} `json:"value"`
+}
+
+// Options of a notifier
+type Options struct {
+	// Modules and Labels, if not empty, are the module names or instance
+	// IDs, and the labels, of the findings that are sent
+	Modules []string
+	Labels  []string
+	// Template is a text/template of the payloads of webhooks, executed
+	// with the findings as .Findings. The default payload is
+	// {"findings": [...]}.
+	Template string
+	// BatchSize is the most findings sent in one message, and Interval the
+	// longest a finding waits for more to be batched with
+	BatchSize int
+	Interval  time.Duration
+	// Retries is the number of times a failed message is sent again
+	Retries int
+	// HTTPClient defaults to a client with a 30 second timeout
+	HTTPClient *http.Client
+	Logger     *logger.Logger
+}
+
+type target struct {
+	format string
+	url    string
+}
+
+// Notifier sends findings to webhooks in batches, in the background
+type Notifier struct {
+	targets  []target
+	options  Options
+	template *template.Template
+	modules  map[string]bool
+	labels   map[string]bool
+	findings chan Finding
+	dropped  atomic.Int64
+	// stop is closed by Close to stop waiting to retry
+	stop chan struct{}
+	done chan struct{}
+}
+
+// New creates a notifier sending findings to targets in the format
+// format=url
+func New(targets []string, options Options) (*Notifier, error) {
+	n := &Notifier{
+		options:  options,
+		modules:  set(options.Modules),
+		labels:   set(options.Labels),
+		findings: make(chan Finding, queueSize),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	for _, spec := range targets {
+		format, rawURL, found := strings.Cut(spec, "=")
+		if !found || rawURL == "" {
+			return nil, fmt.Errorf("invalid notifier format: %s", spec)
+		}
+		if format != FormatWebhook && format != FormatSlack && format != FormatDiscord {
+			return nil, fmt.Errorf("unknown notifier %s", format)
+		}
+		n.targets = append(n.targets, target{format: format, url: rawURL})
+	}
+
+	if options.Template != "" {
+		t, err := template.New("payload").Funcs(template.FuncMap{
+			"json": func(v interface{}) (string, error) {
+				j, err := json.Marshal(v)
+				return string(j), err
+			},
+		}).Parse(options.Template)
+		if err != nil {
+			return nil, fmt.Errorf("invalid notification template: %v", err)
+		}
+		n.template = t
+	}
+	if n.options.BatchSize <= 0 {
+		n.options.BatchSize = 1
+	}
+	if n.options.HTTPClient == nil {
+		n.options.HTTPClient = &http.Client{Timeout: timeout}
+	}
+	if n.options.Logger == nil {
+		n.options.Logger = logger.Discard()
+	}
+
+	go n.run()
+	return n, nil
+}
+
+func set(values []string) map[string]bool {
+	if len(values) == 0 {
+		return nil
+	}
+	m := make(map[string]bool)
+	for _, value := range values {
+		m[value] = true
+	}
+	return m
+}
+
+// Notify queues the findings of v if it's a module output. Findings are
+// dropped with a warning if too many are waiting to be sent, so that writing
+// results never waits for webhooks.
+func (n *Notifier) Notify(v interface{}) {
+	output, ok := v.(modules.ModuleOutput)
+	if !ok {
+		return
+	}
+	if n.modules != nil && !n.modules[output.Module] && !n.modules[output.InstanceID()] {
+		return
+	}
+	for _, result := range output.Results {
+		if n.labels != nil && !n.labels[result.Label] {
+			continue
+		}
+		for _, value := range result.Values() {
+			finding := Finding{
+				Module:    output.InstanceID(),
+				URL:       output.URL,
+				Snapshot:  output.SnapshotURL,
+				Timestamp: output.Timestamp,
+				Label:     result.Label,
+				Value:     value,
+			}
+			select {
+			case n.findings <- finding:
+			default:
+				if n.dropped.Add(1) == 1 {
+					n.options.Logger.Warn.Println("too many findings are waiting to be sent, dropping new ones")
+				}
+			}
+		}
+	}
+}
+
+// Close sends the queued findings and waits until they're sent. Messages
+// that fail from then on aren't retried, so that it returns quickly.
+func (n *Notifier) Close() error {
+	close(n.stop)
+	close(n.findings)
+	<-n.done
+	if dropped := n.dropped.Load(); dropped > 0 {
+		n.options.Logger.Warn.Printf("dropped %d findings that couldn't be sent in time\n", dropped)
+	}
+	return nil
+}
+
+func (n *Notifier) run() {
+	defer close(n.done)
+
+	var batch []Finding
+	timer := time.NewTimer(n.options.Interval)
+	timer.Stop()
+	for {
+		select {
+		case finding, ok := <-n.findings:
+			if !ok {
+				n.send(batch)
+				return
+			}
+			if len(batch) == 0 {
+				timer.Reset(n.options.Interval)
+			}
+			batch = append(batch, finding)
+			if len(batch) >= n.options.BatchSize {
+				timer.Stop()
+				n.send(batch)
+				batch = nil
+			}
+		case <-timer.C:
+			n.send(batch)
+			batch = nil
+		}
+	}
+}
+
+func (n *Notifier) send(findings []Finding) {
+	if len(findings) == 0 {
+		return
+	}
+	for _, target := range n.targets {
+		payload, err := n.payload(target.format, findings)
+		if err != nil {
+			n.options.Logger.Error.Printf("failed to create the %s notification: %v\n", target.format, err)
+			continue
+		}
+		if err := n.post(target.format, target.url, payload); err != nil {
+			n.options.Logger.Error.Printf("failed to send %d findings to the %s notifier: %v\n", len(findings), target.format, err)
+		}
+	}
+}
+
+func (n *Notifier) payload(format string, findings []Finding) ([]byte, error) {
+	switch format {
+	case FormatSlack:
+		return json.Marshal(map[string]string{"text": message(findings, slackLimit)})
+	case FormatDiscord:
+		return json.Marshal(map[string]string{"content": message(findings, discordLimit)})
+	}
+
+	data := struct {
+		Findings []Finding `json:"findings"`
+	}{findings}
+	if n.template == nil {
+		return json.Marshal(data)
+	}
+	var b bytes.Buffer
+	if err := n.template.Execute(&b, data); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// message lists findings as lines of text, up to limit characters
+func message(findings []Finding, limit int) string {
+	var b strings.Builder
+	if len(findings) == 1 {
+		b.WriteString("1 new finding")
+	} else {
+		fmt.Fprintf(&b, "%d new findings", len(findings))
+	}
+	for i, finding := range findings {
+		value := modules.FormatValue(finding.Value)
+		if utf8.RuneCountInString(value) > valueLimit {
+			value = string([]rune(value)[:valueLimit]) + "..."
+		}
+		value = strings.ReplaceAll(value, "`", "'")
+		label := ""
+		if finding.Label != "" {
+			label = " " + finding.Label
+		}
+		line := fmt.Sprintf("\n[%s%s] `%s` in <%s> (%s)", finding.Module, label, value, finding.URL, finding.Timestamp)
+
+		more := fmt.Sprintf("\n...and %d more", len(findings)-i)
+		if b.Len()+len(line) > limit-len(more) {
+			b.WriteString(more)
+			break
+		}
+		b.WriteString(line)
+	}
+	return b.String()
+}
+
+// post sends a payload, retrying on network errors, rate limits and server
+// errors with an increasing delay, or the delay asked for with Retry-After.
+// Errors don't include the URL, since webhook URLs are secrets.
+func (n *Notifier) post(format, rawURL string, payload []byte) error {
+	delay := retryDelay
+	for attempt := 0; ; attempt++ {
+		retryAfter, err := n.postOnce(rawURL, payload)
+		if err == nil {
+			return nil
+		}
+		if retryAfter < 0 || attempt >= n.options.Retries {
+			return err
+		}
+		if retryAfter == 0 {
+			retryAfter = delay
+			delay *= 2
+		}
+		n.options.Logger.Warn.Printf("failed to send to the %s notifier: %v, retrying in %s\n", format, err, retryAfter)
+		timer := time.NewTimer(retryAfter)
+		select {
+		case <-timer.C:
+		case <-n.stop:
+			timer.Stop()
+			return err
+		}
+	}
+}
+
+// postOnce returns the delay before retrying if sending failed, or a
+// negative delay if it shouldn't be retried
+func (n *Notifier) postOnce(rawURL string, payload []byte) (time.Duration, error) {
+	resp, err := n.options.HTTPClient.Post(rawURL, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		if urlErr, ok := err.(*url.Error); ok {
+			err = urlErr.Err
+		}
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	switch {
+	case resp.StatusCode < 300:
+		return 0, nil
+	case resp.StatusCode == http.StatusTooManyRequests:
+		seconds, _ := strconv.Atoi(resp.Header.Get("Retry-After"))
+		return time.Duration(seconds) * time.Second, fmt.Errorf("rate limited")
+	case resp.StatusCode >= 500:
+		return 0, fmt.Errorf("got %s", resp.Status)
+	default:
+		return -1, fmt.Errorf("got %s", resp.Status)
+	}
+}
@@ -0,0 +1,260 @@
+package notify
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mhmdiaa/chronos/v2/modules"
+)
+
+// webhook records the findings it receives, replying with the statuses in
+// replies in turn, and then with 200
+type webhook struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	replies  []int
+	requests int
+	batches  [][]Finding
+}
+
+func newWebhook(t *testing.T, replies ...int) *webhook {
+	h := &webhook{replies: replies}
+	h.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		h.requests++
+		if len(h.replies) > 0 {
+			status := h.replies[0]
+			h.replies = h.replies[1:]
+			if status == http.StatusTooManyRequests {
+				w.Header().Set("Retry-After", "1")
+			}
+			w.WriteHeader(status)
+			return
+		}
+
+		var payload struct {
+			Findings []Finding `json:"findings"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			t.Errorf("invalid payload: %v", err)
+		}
+		h.batches = append(h.batches, payload.Findings)
+	}))
+	t.Cleanup(h.Close)
+	return h
+}
+
+func (h *webhook) received() (int, [][]Finding) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.requests, h.batches
+}
+
+// values returns the values of the findings of every batch
+func values(batches [][]Finding) [][]string {
+	var values [][]string
+	for _, batch := range batches {
+		var v []string
+		for _, finding := range batch {
+			v = append(v, finding.Value.(string))
+		}
+		values = append(values, v)
+	}
+	return values
+}
+
+func output(module, label string, values ...string) modules.ModuleOutput {
+	return modules.ModuleOutput{
+		Module:    module,
+		URL:       "http://example.com/",
+		Timestamp: "20200101000000",
+		Results:   modules.Results{{Label: label, Value: values}},
+	}
+}
+
+func TestBatching(t *testing.T) {
+	hook := newWebhook(t)
+	n, err := New([]string{"webhook=" + hook.URL}, Options{BatchSize: 2, Interval: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.Notify(output("regex", "s3", "a", "b", "c"))
+	n.Close()
+
+	_, batches := hook.received()
+	if got, want := values(batches), [][]string{{"a", "b"}, {"c"}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got batches %v, want %v", got, want)
+	}
+}
+
+func TestInterval(t *testing.T) {
+	hook := newWebhook(t)
+	n, err := New([]string{"webhook=" + hook.URL}, Options{BatchSize: 10, Interval: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n.Close()
+	n.Notify(output("regex", "s3", "a"))
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, batches := hook.received(); len(batches) > 0 {
+			if got, want := values(batches), [][]string{{"a"}}; !reflect.DeepEqual(got, want) {
+				t.Errorf("got batches %v, want %v", got, want)
+			}
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the finding wasn't sent after the interval")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestFiltering(t *testing.T) {
+	hook := newWebhook(t)
+	n, err := New([]string{"webhook=" + hook.URL}, Options{
+		Modules:   []string{"secrets", "regex:s3"},
+		Labels:    []string{"aws", "bucket"},
+		BatchSize: 10,
+		Interval:  time.Hour,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.Notify(output("secrets", "aws", "a"))
+	n.Notify(output("secrets", "jwt", "b"))
+	n.Notify(output("jsluice", "aws", "c"))
+	s3 := output("regex", "bucket", "d")
+	s3.Instance = "s3"
+	n.Notify(s3)
+	n.Notify(output("regex", "bucket", "e"))
+	n.Notify(wayback{})
+	n.Close()
+
+	_, batches := hook.received()
+	if got, want := values(batches), [][]string{{"a", "d"}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got batches %v, want %v", got, want)
+	}
+	if module := batches[0][1].Module; module != "regex:s3" {
+		t.Errorf("got module %s, want regex:s3", module)
+	}
+}
+
+// wayback is a result that isn't a module output
+type wayback struct{}
+
+func TestRetry(t *testing.T) {
+	defer func(delay time.Duration) { retryDelay = delay }(retryDelay)
+	retryDelay = 10 * time.Millisecond
+
+	tests := []struct {
+		name     string
+		replies  []int
+		retries  int
+		requests int
+		sent     bool
+		// atLeast is the least time the retries take
+		atLeast time.Duration
+	}{
+		{"server errors", []int{500, 502}, 3, 3, true, 30 * time.Millisecond},
+		{"retry after", []int{429}, 3, 2, true, time.Second},
+		{"too many failures", []int{500, 500, 500}, 2, 3, false, 30 * time.Millisecond},
+		{"client error", []int{400}, 3, 1, false, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hook := newWebhook(t, tt.replies...)
+			n, err := New([]string{"webhook=" + hook.URL}, Options{BatchSize: 1, Retries: tt.retries})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer n.Close()
+
+			start := time.Now()
+			n.Notify(output("regex", "s3", "a"))
+			deadline := start.Add(5 * time.Second)
+			for {
+				requests, batches := hook.received()
+				if requests >= tt.requests && (!tt.sent || len(batches) > 0) {
+					break
+				}
+				if time.Now().After(deadline) {
+					t.Fatalf("got %d requests, want %d", requests, tt.requests)
+				}
+				time.Sleep(5 * time.Millisecond)
+			}
+			if elapsed := time.Since(start); elapsed < tt.atLeast {
+				t.Errorf("retried after %s, want at least %s", elapsed, tt.atLeast)
+			}
+
+			// Check that no more requests are sent
+			time.Sleep(50 * time.Millisecond)
+			requests, batches := hook.received()
+			if requests != tt.requests {
+				t.Errorf("got %d requests, want %d", requests, tt.requests)
+			}
+			if sent := len(batches) > 0; sent != tt.sent {
+				t.Errorf("sent is %v, want %v", sent, tt.sent)
+			}
+		})
+	}
+}
+
+func TestCloseStopsRetrying(t *testing.T) {
+	hook := newWebhook(t, 500)
+	n, err := New([]string{"webhook=" + hook.URL}, Options{BatchSize: 1, Retries: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.Notify(output("regex", "s3", "a"))
+	for requests, _ := hook.received(); requests == 0; requests, _ = hook.received() {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	start := time.Now()
+	n.Close()
+	if elapsed := time.Since(start); elapsed > retryDelay/2 {
+		t.Errorf("Close waited %s for a retry", elapsed)
+	}
+}
+
+func TestDropWhenFull(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	n, err := New([]string{"webhook=" + server.URL}, Options{BatchSize: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	values := make([]string, 2*queueSize)
+	for i := range values {
+		values[i] = strconv.Itoa(i)
+	}
+	done := make(chan struct{})
+	go func() {
+		n.Notify(output("regex", "s3", values...))
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Notify blocked on a full queue")
+	}
+	if n.dropped.Load() == 0 {
+		t.Error("no findings were dropped")
+	}
+	close(release)
+	n.Close()
+}
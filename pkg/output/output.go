@@ -0,0 +1,240 @@
+package output
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/mhmdiaa/chronos/v2/pkg/wayback"
+)
+
+// Formats of the output
+const (
+	FormatJSONL = "jsonl"
+	FormatJSON  = "json"
+	FormatCSV   = "csv"
+	FormatTSV   = "tsv"
+)
+
+// Table is implemented by values that can be written as rows of CSV and TSV
+// output
+type Table interface {
+	Columns() []string
+	Rows() [][]string
+}
+
+// Writer writes values in one of the output formats. It's safe for
+// concurrent use.
+type Writer interface {
+	Write(v interface{}) error
+	Close() error
+}
+
+func NewWriter(format string, w io.Writer) (Writer, error) {
+	switch format {
+	case FormatJSONL:
+		return &jsonlWriter{w: w}, nil
+	case FormatJSON:
+		return &jsonWriter{w: w}, nil
+	case FormatCSV:
+		return &tableWriter{w: csv.NewWriter(w)}, nil
+	case FormatTSV:
+		writer := csv.NewWriter(w)
+		writer.Comma = '\t'
+		return &tableWriter{w: writer}, nil
+	default:
+		return nil, fmt.Errorf("unknown output format %s", format)
+	}
+}
+
+// IsTable reports whether format writes rows instead of JSON values
+func IsTable(format string) bool {
+	return format == FormatCSV || format == FormatTSV
+}
+
+type jsonlWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (w *jsonlWriter) Write(v interface{}) error {
+	j, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	_, err = w.w.Write(append(j, '\n'))
+	return err
+}
+
+func (w *jsonlWriter) Close() error {
+	return nil
+}
+
+// jsonWriter writes a JSON array, with one value per line
+type jsonWriter struct {
+	mu    sync.Mutex
+	w     io.Writer
+	count int
+}
+
+func (w *jsonWriter) Write(v interface{}) error {
+	j, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	prefix := ",\n"
+	if w.count == 0 {
+		prefix = "[\n"
+	}
+	w.count++
+	_, err = io.WriteString(w.w, prefix+string(j))
+	return err
+}
+
+func (w *jsonWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.count == 0 {
+		_, err := io.WriteString(w.w, "[]\n")
+		return err
+	}
+	_, err := io.WriteString(w.w, "\n]\n")
+	return err
+}
+
+// tableWriter writes the rows of Table values, with a header taken from the
+// columns of the first value
+type tableWriter struct {
+	mu     sync.Mutex
+	w      *csv.Writer
+	header bool
+}
+
+func (w *tableWriter) Write(v interface{}) error {
+	table, ok := v.(Table)
+	if !ok {
+		return fmt.Errorf("%T can't be written as rows", v)
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.header {
+		if err := w.w.Write(table.Columns()); err != nil {
+			return err
+		}
+		w.header = true
+	}
+	if err := w.w.WriteAll(table.Rows()); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (w *tableWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.w.Flush()
+	return w.w.Error()
+}
+
+// Grouped is implemented by values that are written to separate files in an
+// output directory. The files are named name, in a directory named after the
+// host of url.
+type Grouped interface {
+	Group() (url, name string)
+}
+
+// Dir writes values to files in a directory, and copies them to another
+// writer
+type Dir struct {
+	dir    string
+	format string
+	copy   Writer
+
+	mu      sync.Mutex
+	writers map[string]*dirFile
+}
+
+type dirFile struct {
+	file   *os.File
+	writer Writer
+}
+
+func NewDir(dir, format string, copy Writer) (*Dir, error) {
+	if _, err := NewWriter(format, io.Discard); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create the output directory %s: %v", dir, err)
+	}
+	return &Dir{
+		dir:     dir,
+		format:  format,
+		copy:    copy,
+		writers: make(map[string]*dirFile),
+	}, nil
+}
+
+func (d *Dir) Write(v interface{}) error {
+	grouped, ok := v.(Grouped)
+	if !ok {
+		return fmt.Errorf("%T can't be written to the output directory", v)
+	}
+	rawURL, name := grouped.Group()
+
+	writer, err := d.writer(wayback.HostDir(rawURL), name)
+	if err != nil {
+		return err
+	}
+	if err := writer.Write(v); err != nil {
+		return err
+	}
+	return d.copy.Write(v)
+}
+
+func (d *Dir) writer(host, name string) (Writer, error) {
+	path := filepath.Join(d.dir, host, wayback.SafeName(name)+"."+d.format)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if f, exists := d.writers[path]; exists {
+		return f.writer, nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	// Only jsonl files stay valid when appended to, so files of other
+	// formats are overwritten
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if d.format == FormatJSONL {
+		flags = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	}
+	file, err := os.OpenFile(path, flags, 0666)
+	if err != nil {
+		return nil, err
+	}
+	writer, _ := NewWriter(d.format, file)
+	d.writers[path] = &dirFile{file: file, writer: writer}
+	return writer, nil
+}
+
+func (d *Dir) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var errs []error
+	for _, f := range d.writers {
+		errs = append(errs, f.writer.Close(), f.file.Close())
+	}
+	errs = append(errs, d.copy.Close())
+	return errors.Join(errs...)
+}
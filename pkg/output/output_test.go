@@ -0,0 +1,41 @@
+package output
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mhmdiaa/chronos/v2/pkg/wayback"
+)
+
+func TestDirPaths(t *testing.T) {
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{"http://example.com/", "example.com/snapshots.jsonl"},
+		{"http://Example.com:8080/", "example.com_8080/snapshots.jsonl"},
+		{"http://../", "_../snapshots.jsonl"},
+		{"http://./", "_./snapshots.jsonl"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			dir := filepath.Join(t.TempDir(), "out")
+			copy, _ := NewWriter(FormatJSONL, io.Discard)
+			d, err := NewDir(dir, FormatJSONL, copy)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := d.Write(wayback.Snapshot{OriginalURL: tt.url}); err != nil {
+				t.Fatal(err)
+			}
+			if err := d.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := os.Stat(filepath.Join(dir, filepath.FromSlash(tt.want))); err != nil {
+				t.Errorf("%s wasn't written: %v", tt.want, err)
+			}
+		})
+	}
+}
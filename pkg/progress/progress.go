@@ -0,0 +1,268 @@
+package progress
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type Tracker struct {
+	total    atomic.Int64
+	fetched  atomic.Int64
+	failed   atomic.Int64
+	inFlight atomic.Int64
+	bytes    atomic.Int64
+	start    time.Time
+
+	mu      sync.Mutex
+	results map[string]int
+	errors  map[string]int
+}
+
+func NewTracker(total int) *Tracker {
+	t := &Tracker{
+		start:   time.Now(),
+		results: make(map[string]int),
+		errors:  make(map[string]int),
+	}
+	t.total.Store(int64(total))
+	return t
+}
+
+// AddTotal increases the number of snapshots expected to be fetched
+func (t *Tracker) AddTotal(n int) {
+	t.total.Add(int64(n))
+}
+
+func (t *Tracker) FetchStarted() {
+	t.inFlight.Add(1)
+}
+
+func (t *Tracker) FetchSucceeded(bytes int) {
+	t.inFlight.Add(-1)
+	t.fetched.Add(1)
+	t.bytes.Add(int64(bytes))
+}
+
+func (t *Tracker) FetchFailed(cause string) {
+	t.inFlight.Add(-1)
+	t.failed.Add(1)
+	t.mu.Lock()
+	t.errors[cause]++
+	t.mu.Unlock()
+}
+
+func (t *Tracker) AddResult(module string) {
+	t.mu.Lock()
+	t.results[module]++
+	t.mu.Unlock()
+}
+
+// Stats are the statistics of a run
+type Stats struct {
+	Total    int64          `json:"total"`
+	Fetched  int64          `json:"fetched"`
+	Failed   int64          `json:"failed"`
+	Bytes    int64          `json:"bytes"`
+	Duration time.Duration  `json:"duration"`
+	Results  map[string]int `json:"results"`
+	Errors   map[string]int `json:"errors"`
+}
+
+func (t *Tracker) Stats() Stats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	stats := Stats{
+		Total:    t.total.Load(),
+		Fetched:  t.fetched.Load(),
+		Failed:   t.failed.Load(),
+		Bytes:    t.bytes.Load(),
+		Duration: time.Since(t.start),
+		Results:  make(map[string]int),
+		Errors:   make(map[string]int),
+	}
+	for module, count := range t.results {
+		stats.Results[module] = count
+	}
+	for cause, count := range t.errors {
+		stats.Errors[cause] = count
+	}
+	return stats
+}
+
+// Status returns a one-line description of the current progress
+func (t *Tracker) Status() string {
+	fetched, failed, total := t.fetched.Load(), t.failed.Load(), t.total.Load()
+	status := fmt.Sprintf("%d/%d fetched, %d failed, %d in flight, %s",
+		fetched, total, failed, t.inFlight.Load(), formatBytes(t.bytes.Load()))
+
+	if results := t.formatCounts(t.results); results != "" {
+		status += " | " + results
+	}
+
+	done := fetched + failed
+	if done > 0 && done < total {
+		elapsed := time.Since(t.start)
+		eta := time.Duration(float64(elapsed) / float64(done) * float64(total-done))
+		status += " | ETA " + eta.Round(time.Second).String()
+	}
+	return status
+}
+
+// Summary returns the end-of-run statistics, one line per entry
+func (t *Tracker) Summary() []string {
+	fetched, failed := t.fetched.Load(), t.failed.Load()
+	summary := []string{
+		fmt.Sprintf("Fetched %d of %d snapshots (%d failed, %s) in %s",
+			fetched, t.total.Load(), failed, formatBytes(t.bytes.Load()), time.Since(t.start).Round(time.Millisecond)),
+	}
+	if results := t.formatCounts(t.results); results != "" {
+		summary = append(summary, "Results: "+results)
+	}
+	if errors := t.formatCounts(t.errors); errors != "" {
+		summary = append(summary, "Errors: "+errors)
+	}
+	return summary
+}
+
+func (t *Tracker) formatCounts(counts map[string]int) string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	keys := make([]string, 0, len(counts))
+	for key := range counts {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	parts := make([]string, len(keys))
+	for i, key := range keys {
+		parts[i] = fmt.Sprintf("%s=%d", key, counts[key])
+	}
+	return strings.Join(parts, ", ")
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// Reporter periodically displays the status of a tracker. On a terminal it
+// redraws a single status line, otherwise it writes plain log lines.
+type Reporter struct {
+	tracker  *Tracker
+	out      io.Writer
+	tty      bool
+	interval time.Duration
+	log      func(string)
+
+	mu   sync.Mutex
+	line string
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewReporter creates a reporter writing to stderr. log is used to write
+// the status when stderr is not a terminal.
+func NewReporter(tracker *Tracker, interval time.Duration, log func(string)) *Reporter {
+	return &Reporter{
+		tracker:  tracker,
+		out:      os.Stderr,
+		tty:      isTerminal(os.Stderr),
+		interval: interval,
+		log:      log,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+func (r *Reporter) IsTerminal() bool {
+	return r.tty
+}
+
+func (r *Reporter) Start() {
+	interval := r.interval
+	if r.tty {
+		interval = 200 * time.Millisecond
+	}
+
+	go func() {
+		defer close(r.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				r.report()
+			case <-r.stop:
+				r.clear()
+				return
+			}
+		}
+	}()
+}
+
+func (r *Reporter) Stop() {
+	close(r.stop)
+	<-r.done
+}
+
+func (r *Reporter) report() {
+	status := r.tracker.Status()
+	if !r.tty {
+		r.log(status)
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.line = status
+	fmt.Fprint(r.out, "\r\033[K"+r.line)
+}
+
+func (r *Reporter) clear() {
+	if !r.tty {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.line = ""
+	fmt.Fprint(r.out, "\r\033[K")
+}
+
+// Write clears the status line, writes p and redraws the status line, so
+// that log messages written through the reporter don't garble the display
+func (r *Reporter) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.line != "" {
+		fmt.Fprint(r.out, "\r\033[K")
+	}
+	n, err := r.out.Write(p)
+	if r.line != "" {
+		fmt.Fprint(r.out, r.line)
+	}
+	return n, err
+}
@@ -0,0 +1,360 @@
+package report
+
+import (
+	"bufio"
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/mhmdiaa/chronos/v2/modules"
+	"github.com/mhmdiaa/chronos/v2/pkg/logger"
+	"github.com/mhmdiaa/chronos/v2/pkg/progress"
+	"github.com/mhmdiaa/chronos/v2/pkg/timeline"
+	"github.com/mhmdiaa/chronos/v2/pkg/wayback"
+)
+
+// RunInfo describes a run of chronos
+type RunInfo struct {
+	Target       string         `json:"target"`
+	Modules      string         `json:"modules"`
+	ModuleConfig []string       `json:"module_config,omitempty"`
+	Filters      []string       `json:"filters,omitempty"`
+	StartedAt    time.Time      `json:"started_at"`
+	FinishedAt   time.Time      `json:"finished_at"`
+	Snapshots    int            `json:"snapshots"`
+	Stats        progress.Stats `json:"stats"`
+}
+
+func WriteRunInfo(path string, info RunInfo) error {
+	j, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, append(j, '\n'), 0666); err != nil {
+		return fmt.Errorf("failed to write the run info to %s: %v", path, err)
+	}
+	return nil
+}
+
+func ReadRunInfo(path string) (*RunInfo, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the run info %s: %v", path, err)
+	}
+	var info RunInfo
+	if err := json.Unmarshal(data, &info); err != nil {
+		return nil, fmt.Errorf("failed to parse the run info %s: %v", path, err)
+	}
+	return &info, nil
+}
+
+// Input is what the report is built from: module results, timeline entries
+// and error records read from chronos output files
+type Input struct {
+	Entries []timeline.Entry
+	Records []logger.Record
+	// Values that aren't any of the above
+	Unknown int
+}
+
+// ReadInputs reads output files written with the jsonl or json formats
+func ReadInputs(paths []string) (*Input, error) {
+	input := &Input{}
+	history := timeline.New()
+	for _, path := range paths {
+		if err := input.read(path, history); err != nil {
+			return nil, err
+		}
+	}
+	input.Entries = append(input.Entries, history.Entries()...)
+	return input, nil
+}
+
+func (input *Input) read(path string, history *timeline.Timeline) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	reader := bufio.NewReader(f)
+	decoder := json.NewDecoder(reader)
+	if first, err := peekNonSpace(reader); err == nil && first == '[' {
+		// A file written with the json format
+		if _, err := decoder.Token(); err != nil {
+			return fmt.Errorf("failed to parse %s: %v", path, err)
+		}
+	}
+
+	for decoder.More() {
+		var raw json.RawMessage
+		if err := decoder.Decode(&raw); err != nil {
+			return fmt.Errorf("failed to parse %s: %v", path, err)
+		}
+		if err := input.add(raw, history); err != nil {
+			return fmt.Errorf("failed to parse %s: %v", path, err)
+		}
+	}
+	return nil
+}
+
+func peekNonSpace(reader *bufio.Reader) (byte, error) {
+	for n := 1; ; n++ {
+		b, err := reader.Peek(n)
+		if err != nil {
+			return 0, err
+		}
+		if c := b[n-1]; c != ' ' && c != '\t' && c != '\r' && c != '\n' {
+			return c, nil
+		}
+	}
+}
+
+func (input *Input) add(raw json.RawMessage, history *timeline.Timeline) error {
+	var probe struct {
+		Type      string          `json:"type"`
+		FirstSeen string          `json:"first_seen"`
+		Results   json.RawMessage `json:"results"`
+	}
+	if err := json.Unmarshal(raw, &probe); err != nil {
+		input.Unknown++
+		return nil
+	}
+
+	switch {
+	case probe.Type == logger.RecordError || probe.Type == logger.RecordSkip:
+		var record logger.Record
+		if err := json.Unmarshal(raw, &record); err != nil {
+			return err
+		}
+		input.Records = append(input.Records, record)
+	case probe.FirstSeen != "":
+		var entry timeline.Entry
+		if err := json.Unmarshal(raw, &entry); err != nil {
+			return err
+		}
+		input.Entries = append(input.Entries, entry)
+	case probe.Results != nil:
+		var output modules.ModuleOutput
+		if err := json.Unmarshal(raw, &output); err != nil {
+			return err
+		}
+		history.Add(output)
+	default:
+		input.Unknown++
+	}
+	return nil
+}
+
+//go:embed report.html
+var reportTemplate string
+
+var templates = template.Must(template.New("report").Funcs(template.FuncMap{
+	"date": formatTimestamp,
+}).Parse(reportTemplate))
+
+type report struct {
+	Title     string
+	Generated string
+	Run       *RunInfo
+	Findings  int
+	Modules   []count
+	Years     []bar
+	Targets   []target
+	Records   []logger.Record
+	Classes   []count
+}
+
+type count struct {
+	Name  string
+	Count int
+}
+
+type bar struct {
+	Year    string
+	Count   int
+	Percent int
+}
+
+type target struct {
+	Host     string
+	Findings int
+	Modules  []moduleGroup
+}
+
+type moduleGroup struct {
+	Module   string
+	Findings int
+	Labels   []labelGroup
+}
+
+type labelGroup struct {
+	Label    string
+	Findings []finding
+}
+
+type finding struct {
+	Value       string
+	URL         string
+	FirstSeen   string
+	LastSeen    string
+	Count       int
+	Appeared    string
+	Disappeared string
+	Snapshots   []link
+	More        int
+}
+
+type link struct {
+	Timestamp string
+	URL       string
+}
+
+// Findings show links to this many snapshots
+const maxLinks = 20
+
+// Render writes a self-contained HTML report of input. info may be nil.
+func Render(w io.Writer, title string, info *RunInfo, input *Input) error {
+	r := report{
+		Title:     title,
+		Generated: time.Now().UTC().Format("2006-01-02 15:04 MST"),
+		Run:       info,
+		Findings:  len(input.Entries),
+		Records:   input.Records,
+	}
+
+	modulesCount := make(map[string]int)
+	years := make(map[string]int)
+	targets := make(map[string]map[string]map[string][]finding)
+	for _, entry := range input.Entries {
+		module := entry.Module
+		if entry.Instance != "" {
+			module += ":" + entry.Instance
+		}
+		modulesCount[module]++
+		if len(entry.FirstSeen) >= 4 {
+			years[entry.FirstSeen[:4]]++
+		}
+
+		host := wayback.Host(entry.URL)
+		if host == "" {
+			host = entry.URL
+		}
+		if targets[host] == nil {
+			targets[host] = make(map[string]map[string][]finding)
+		}
+		if targets[host][module] == nil {
+			targets[host][module] = make(map[string][]finding)
+		}
+		targets[host][module][entry.Label] = append(targets[host][module][entry.Label], newFinding(entry))
+	}
+
+	r.Modules = sortedCounts(modulesCount)
+	r.Years = bars(years)
+	r.Targets = groupTargets(targets)
+
+	classes := make(map[string]int)
+	for _, record := range input.Records {
+		classes[record.Type+": "+record.Class]++
+	}
+	r.Classes = sortedCounts(classes)
+
+	var buf bytes.Buffer
+	if err := templates.Execute(&buf, r); err != nil {
+		return err
+	}
+	_, err := buf.WriteTo(w)
+	return err
+}
+
+var snapshotTimestamp = regexp.MustCompile(`/web/(\d{14})`)
+
+func newFinding(entry timeline.Entry) finding {
+	f := finding{
+		Value:       modules.FormatValue(entry.Value),
+		URL:         entry.URL,
+		FirstSeen:   entry.FirstSeen,
+		LastSeen:    entry.LastSeen,
+		Count:       entry.Count,
+		Appeared:    entry.Appeared,
+		Disappeared: entry.Disappeared,
+	}
+	for i, snapshot := range entry.Snapshots {
+		if i == maxLinks {
+			f.More = len(entry.Snapshots) - maxLinks
+			break
+		}
+		l := link{URL: snapshot}
+		if match := snapshotTimestamp.FindStringSubmatch(snapshot); match != nil {
+			l.Timestamp = match[1]
+		}
+		f.Snapshots = append(f.Snapshots, l)
+	}
+	return f
+}
+
+func groupTargets(targets map[string]map[string]map[string][]finding) []target {
+	var grouped []target
+	for _, host := range sortedKeys(targets) {
+		t := target{Host: host}
+		for _, module := range sortedKeys(targets[host]) {
+			m := moduleGroup{Module: module}
+			for _, label := range sortedKeys(targets[host][module]) {
+				findings := targets[host][module][label]
+				m.Labels = append(m.Labels, labelGroup{Label: label, Findings: findings})
+				m.Findings += len(findings)
+			}
+			t.Modules = append(t.Modules, m)
+			t.Findings += m.Findings
+		}
+		grouped = append(grouped, t)
+	}
+	return grouped
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func sortedCounts(counts map[string]int) []count {
+	var sorted []count
+	for _, name := range sortedKeys(counts) {
+		sorted = append(sorted, count{Name: name, Count: counts[name]})
+	}
+	return sorted
+}
+
+func bars(years map[string]int) []bar {
+	max := 0
+	for _, n := range years {
+		if n > max {
+			max = n
+		}
+	}
+	var result []bar
+	for _, year := range sortedKeys(years) {
+		result = append(result, bar{Year: year, Count: years[year], Percent: years[year] * 100 / max})
+	}
+	return result
+}
+
+// formatTimestamp formats a Wayback timestamp as a date
+func formatTimestamp(timestamp string) string {
+	t, err := time.Parse("20060102150405", timestamp)
+	if err != nil {
+		return timestamp
+	}
+	return t.Format("2006-01-02")
+}
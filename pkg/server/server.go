@@ -0,0 +1,430 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mhmdiaa/chronos/v2/modules"
+	"github.com/mhmdiaa/chronos/v2/pkg/progress"
+	"github.com/mhmdiaa/chronos/v2/pkg/wayback"
+)
+
+// Request is a scan submitted to the API
+type Request struct {
+	Target       string                          `json:"target"`
+	Modules      []string                        `json:"modules,omitempty"`
+	ModuleConfig map[string]modules.ModuleConfig `json:"module_config,omitempty"`
+	Filters      wayback.Filters                 `json:"filters"`
+	Threads      int                             `json:"threads,omitempty"`
+	ChainDepth   int                             `json:"chain_depth,omitempty"`
+	ChainModules []string                        `json:"chain_modules,omitempty"`
+	ChainHosts   []string                        `json:"chain_hosts,omitempty"`
+	Timeline     bool                            `json:"timeline,omitempty"`
+	Dedup        string                          `json:"dedup,omitempty"`
+}
+
+// ScanFunc runs a scan until it's done or ctx is canceled. Results are
+// passed to write, and started is called with the tracker of the scan's
+// progress once its snapshots are found.
+type ScanFunc func(ctx context.Context, request Request, write func(v interface{}), started func(*progress.Tracker)) error
+
+// Statuses of a scan
+const (
+	StatusQueued   = "queued"
+	StatusRunning  = "running"
+	StatusDone     = "done"
+	StatusFailed   = "failed"
+	StatusCanceled = "canceled"
+)
+
+// Status describes a scan
+type Status struct {
+	ID         string          `json:"id"`
+	Status     string          `json:"status"`
+	Request    Request         `json:"request"`
+	Error      string          `json:"error,omitempty"`
+	CreatedAt  time.Time       `json:"created_at"`
+	StartedAt  *time.Time      `json:"started_at,omitempty"`
+	FinishedAt *time.Time      `json:"finished_at,omitempty"`
+	Results    int             `json:"results"`
+	Progress   *progress.Stats `json:"progress,omitempty"`
+}
+
+// Server is an HTTP API to submit scans, follow their status and results,
+// and cancel them. At most maxScans scans run at the same time, and the
+// others wait in the order they were submitted. Only the maxFinished most
+// recent finished scans are kept, along with their results.
+type Server struct {
+	run         ScanFunc
+	defaults    Request
+	slots       chan struct{}
+	maxFinished int
+
+	mu    sync.Mutex
+	scans map[string]*scan
+	order []string
+}
+
+// New creates a server. Fields missing from submitted requests are taken
+// from defaults, and defaults.Threads, if set, is also the most threads a
+// request can use. maxScans must be at least 1.
+func New(run ScanFunc, defaults Request, maxScans, maxFinished int) *Server {
+	return &Server{
+		run:         run,
+		defaults:    defaults,
+		slots:       make(chan struct{}, maxScans),
+		maxFinished: maxFinished,
+		scans:       make(map[string]*scan),
+	}
+}
+
+type scan struct {
+	cancel context.CancelFunc
+
+	mu      sync.Mutex
+	status  Status
+	tracker *progress.Tracker
+	results [][]byte
+	// updated is closed and replaced whenever results are added or the
+	// scan finishes
+	updated chan struct{}
+}
+
+func (s *scan) Status() Status {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	status := s.status
+	status.Results = len(s.results)
+	if s.tracker != nil {
+		stats := s.tracker.Stats()
+		status.Progress = &stats
+	}
+	return status
+}
+
+func (s *scan) add(v interface{}) {
+	j, err := json.Marshal(v)
+	if err != nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.results = append(s.results, j)
+	s.notify()
+}
+
+func (s *scan) notify() {
+	close(s.updated)
+	s.updated = make(chan struct{})
+}
+
+func (s *scan) setTracker(tracker *progress.Tracker) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tracker = tracker
+}
+
+func (s *scan) start() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	s.status.Status = StatusRunning
+	s.status.StartedAt = &now
+}
+
+func (s *scan) finish(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	s.status.FinishedAt = &now
+	if s.tracker != nil {
+		stats := s.tracker.Stats()
+		s.status.Progress = &stats
+		s.tracker = nil
+	}
+	switch {
+	case err == nil:
+		s.status.Status = StatusDone
+	case errors.Is(err, context.Canceled):
+		s.status.Status = StatusCanceled
+	default:
+		s.status.Status = StatusFailed
+		s.status.Error = err.Error()
+	}
+	s.notify()
+}
+
+func (s *scan) finished() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.status.FinishedAt != nil
+}
+
+// since returns the results after the first n, a channel closed when there
+// are more, and whether the scan is finished
+func (s *scan) since(n int) ([][]byte, <-chan struct{}, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if n > len(s.results) {
+		n = len(s.results)
+	}
+	return s.results[n:], s.updated, s.status.FinishedAt != nil
+}
+
+// Submit queues a scan and returns its status
+func (srv *Server) Submit(request Request) Status {
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &scan{
+		cancel: cancel,
+		status: Status{
+			ID:        newID(),
+			Status:    StatusQueued,
+			Request:   request,
+			CreatedAt: time.Now(),
+		},
+		updated: make(chan struct{}),
+	}
+
+	srv.mu.Lock()
+	srv.scans[s.status.ID] = s
+	srv.order = append(srv.order, s.status.ID)
+	srv.mu.Unlock()
+
+	go func() {
+		defer srv.prune()
+		defer cancel()
+		select {
+		case srv.slots <- struct{}{}:
+			defer func() { <-srv.slots }()
+		case <-ctx.Done():
+			s.finish(ctx.Err())
+			return
+		}
+		s.start()
+		s.finish(srv.run(ctx, request, s.add, s.setTracker))
+	}()
+
+	return s.Status()
+}
+
+// Delete removes a finished scan, returning false if it's still queued or
+// running
+func (srv *Server) Delete(id string) bool {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	s := srv.scans[id]
+	if s == nil || !s.finished() {
+		return false
+	}
+	srv.remove(id)
+	return true
+}
+
+// prune removes the oldest finished scans beyond maxFinished
+func (srv *Server) prune() {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	var finished []string
+	for _, id := range srv.order {
+		if srv.scans[id].finished() {
+			finished = append(finished, id)
+		}
+	}
+	for len(finished) > srv.maxFinished {
+		srv.remove(finished[0])
+		finished = finished[1:]
+	}
+}
+
+// remove removes a scan. The lock must be held.
+func (srv *Server) remove(id string) {
+	delete(srv.scans, id)
+	for i, other := range srv.order {
+		if other == id {
+			srv.order = append(srv.order[:i], srv.order[i+1:]...)
+			break
+		}
+	}
+}
+
+func newID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Shutdown cancels every scan
+func (srv *Server) Shutdown() {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	for _, s := range srv.scans {
+		s.cancel()
+	}
+}
+
+// ServeHTTP handles the API's endpoints:
+//
+//	POST   /scans              submit a scan
+//	GET    /scans              list scans
+//	GET    /scans/{id}         get the status and progress of a scan
+//	DELETE /scans/{id}         cancel a scan, or delete it if it's finished
+//	GET    /scans/{id}/results stream the results of a scan as JSON lines, or
+//	                           as server-sent events with Accept: text/event-stream
+func (srv *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if parts[0] != "scans" || len(parts) > 3 || (len(parts) == 3 && parts[2] != "results") {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+
+	if len(parts) == 1 {
+		switch r.Method {
+		case http.MethodGet:
+			srv.list(w)
+		case http.MethodPost:
+			srv.submit(w, r)
+		default:
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		}
+		return
+	}
+
+	srv.mu.Lock()
+	s := srv.scans[parts[1]]
+	srv.mu.Unlock()
+	if s == nil {
+		writeError(w, http.StatusNotFound, "scan not found")
+		return
+	}
+
+	switch {
+	case len(parts) == 3 && r.Method == http.MethodGet:
+		streamResults(w, r, s)
+	case len(parts) == 2 && r.Method == http.MethodGet:
+		writeJSON(w, http.StatusOK, s.Status())
+	case len(parts) == 2 && r.Method == http.MethodDelete:
+		if srv.Delete(parts[1]) {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		s.cancel()
+		writeJSON(w, http.StatusOK, s.Status())
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+func (srv *Server) list(w http.ResponseWriter) {
+	srv.mu.Lock()
+	scans := make([]*scan, len(srv.order))
+	for i, id := range srv.order {
+		scans[i] = srv.scans[id]
+	}
+	srv.mu.Unlock()
+
+	statuses := make([]Status, len(scans))
+	for i, s := range scans {
+		statuses[i] = s.Status()
+	}
+	writeJSON(w, http.StatusOK, statuses)
+}
+
+func (srv *Server) submit(w http.ResponseWriter, r *http.Request) {
+	request := srv.defaults
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&request); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid request: %v", err))
+		return
+	}
+	if request.Target == "" {
+		writeError(w, http.StatusBadRequest, "target not specified")
+		return
+	}
+	// Scans can't read or write files of the server's choosing
+	ids := make([]string, 0, len(request.ModuleConfig))
+	for id := range request.ModuleConfig {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		if paths := modules.PathOptions(id, request.ModuleConfig[id]); len(paths) > 0 {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("option %s.%s is a path, which can't be set through the API", id, paths[0]))
+			return
+		}
+	}
+	if srv.defaults.Threads > 0 && (request.Threads <= 0 || request.Threads > srv.defaults.Threads) {
+		request.Threads = srv.defaults.Threads
+	}
+	writeJSON(w, http.StatusCreated, srv.Submit(request))
+}
+
+// streamResults writes the results of a scan until it's finished. Server-sent
+// events have the index of the result as their ID, so that clients can
+// resume with Last-Event-ID.
+func streamResults(w http.ResponseWriter, r *http.Request, s *scan) {
+	sse := strings.Contains(r.Header.Get("Accept"), "text/event-stream")
+	sent := 0
+	if sse {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		if id, err := strconv.Atoi(r.Header.Get("Last-Event-ID")); err == nil && id > 0 {
+			sent = id
+		}
+	} else {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+	}
+	flusher, _ := w.(http.Flusher)
+
+	for {
+		results, updated, finished := s.since(sent)
+		for _, result := range results {
+			sent++
+			var err error
+			if sse {
+				_, err = fmt.Fprintf(w, "id: %d\ndata: %s\n\n", sent, result)
+			} else {
+				_, err = fmt.Fprintf(w, "%s\n", result)
+			}
+			if err != nil {
+				return
+			}
+		}
+		if finished {
+			if sse {
+				j, _ := json.Marshal(s.Status())
+				fmt.Fprintf(w, "event: end\ndata: %s\n\n", j)
+			}
+			return
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+
+		select {
+		case <-updated:
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, code int, message string) {
+	writeJSON(w, code, map[string]string{"error": message})
+}
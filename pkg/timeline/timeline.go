@@ -0,0 +1,192 @@
+package timeline
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/mhmdiaa/chronos/v2/modules"
+	"github.com/mhmdiaa/chronos/v2/pkg/wayback"
+)
+
+// Entry is the history of a value found by a module in the snapshots of a
+// URL. Appeared is set if the value is missing from earlier snapshots of the
+// URL, and Disappeared is the timestamp of the first snapshot missing the
+// value after it was last seen.
+type Entry struct {
+	Module      string      `json:"module"`
+	Instance    string      `json:"instance,omitempty"`
+	URL         string      `json:"url"`
+	Label       string      `json:"label,omitempty"`
+	Value       interface{} `json:"value"`
+	FirstSeen   string      `json:"first_seen"`
+	LastSeen    string      `json:"last_seen"`
+	Count       int         `json:"count"`
+	Snapshots   []string    `json:"snapshots"`
+	Appeared    string      `json:"appeared,omitempty"`
+	Disappeared string      `json:"disappeared,omitempty"`
+}
+
+func (entry Entry) Columns() []string {
+	return []string{"module", "url", "label", "value", "first_seen", "last_seen", "count", "appeared", "disappeared", "snapshots"}
+}
+
+func (entry Entry) Rows() [][]string {
+	return [][]string{{
+		entry.module(), entry.URL, entry.Label, modules.FormatValue(entry.Value), entry.FirstSeen, entry.LastSeen,
+		strconv.Itoa(entry.Count), entry.Appeared, entry.Disappeared, strings.Join(entry.Snapshots, " "),
+	}}
+}
+
+func (entry Entry) Group() (string, string) {
+	return entry.URL, entry.module()
+}
+
+func (entry Entry) module() string {
+	if entry.Instance == "" {
+		return entry.Module
+	}
+	return entry.Module + ":" + entry.Instance
+}
+
+// Timeline collects module outputs into an entry for every URL, module
+// instance, label and value
+type Timeline struct {
+	mu         sync.Mutex
+	timestamps map[snapshotKey]map[string]bool
+	entries    map[entryKey]*entry
+}
+
+type snapshotKey struct {
+	instance string
+	url      string
+}
+
+type entryKey struct {
+	instance string
+	url      string
+	label    string
+	value    string
+}
+
+type entry struct {
+	module    string
+	instance  string
+	value     interface{}
+	snapshots map[string]string
+}
+
+func New() *Timeline {
+	return &Timeline{
+		timestamps: make(map[snapshotKey]map[string]bool),
+		entries:    make(map[entryKey]*entry),
+	}
+}
+
+// AddSnapshot records a snapshot handled by a module instance, so that
+// values the instance didn't find in it can be reported as disappeared.
+// Snapshots that an instance didn't handle, or failed to, are left out.
+func (t *Timeline) AddSnapshot(instance string, snapshot wayback.Snapshot) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	key := snapshotKey{instance: instance, url: snapshot.OriginalURL}
+	if t.timestamps[key] == nil {
+		t.timestamps[key] = make(map[string]bool)
+	}
+	t.timestamps[key][snapshot.Timestamp] = true
+}
+
+func (t *Timeline) Add(output modules.ModuleOutput) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, result := range output.Results {
+		for _, value := range result.Values() {
+			key := entryKey{
+				instance: output.InstanceID(),
+				url:      output.URL,
+				label:    result.Label,
+				value:    modules.FormatValue(value),
+			}
+			e, exists := t.entries[key]
+			if !exists {
+				e = &entry{
+					module:    output.Module,
+					instance:  output.Instance,
+					value:     value,
+					snapshots: make(map[string]string),
+				}
+				t.entries[key] = e
+			}
+			e.snapshots[output.Timestamp] = output.SnapshotURL
+		}
+	}
+}
+
+// Entries returns the entries sorted by URL, module instance, label and
+// first appearance
+func (t *Timeline) Entries() []Entry {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	keys := make([]entryKey, 0, len(t.entries))
+	for key := range t.entries {
+		keys = append(keys, key)
+	}
+
+	entries := make([]Entry, len(keys))
+	for i, key := range keys {
+		e := t.entries[key]
+		seen := make([]string, 0, len(e.snapshots))
+		for timestamp := range e.snapshots {
+			seen = append(seen, timestamp)
+		}
+		sort.Strings(seen)
+
+		snapshots := make([]string, len(seen))
+		for j, timestamp := range seen {
+			snapshots[j] = e.snapshots[timestamp]
+		}
+
+		entries[i] = Entry{
+			Module:    e.module,
+			Instance:  e.instance,
+			URL:       key.url,
+			Label:     key.label,
+			Value:     e.value,
+			FirstSeen: seen[0],
+			LastSeen:  seen[len(seen)-1],
+			Count:     len(seen),
+			Snapshots: snapshots,
+		}
+		for timestamp := range t.timestamps[snapshotKey{instance: key.instance, url: key.url}] {
+			if timestamp < entries[i].FirstSeen {
+				entries[i].Appeared = entries[i].FirstSeen
+			}
+			if timestamp > entries[i].LastSeen && (entries[i].Disappeared == "" || timestamp < entries[i].Disappeared) {
+				entries[i].Disappeared = timestamp
+			}
+		}
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if a.URL != b.URL {
+			return a.URL < b.URL
+		}
+		if a.Module != b.Module {
+			return a.Module < b.Module
+		}
+		if a.Instance != b.Instance {
+			return a.Instance < b.Instance
+		}
+		if a.Label != b.Label {
+			return a.Label < b.Label
+		}
+		if a.FirstSeen != b.FirstSeen {
+			return a.FirstSeen < b.FirstSeen
+		}
+		return modules.FormatValue(a.Value) < modules.FormatValue(b.Value)
+	})
+	return entries
+}
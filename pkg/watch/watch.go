@@ -0,0 +1,259 @@
+package watch
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/mhmdiaa/chronos/v2/pkg/chronos"
+	"github.com/mhmdiaa/chronos/v2/pkg/dedup"
+	"github.com/mhmdiaa/chronos/v2/pkg/logger"
+	"github.com/mhmdiaa/chronos/v2/pkg/wayback"
+)
+
+// State is what a watcher remembers between polls: the timestamp of the
+// newest snapshot seen of every target, and the values already written. It
+// implements dedup.Store, keeping only the keys of values.
+type State struct {
+	mu       sync.Mutex
+	lastSeen map[string]string
+	seen     map[string]bool
+}
+
+type stateFile struct {
+	LastSeen map[string]string `json:"last_seen"`
+	Seen     []string          `json:"seen"`
+}
+
+// LoadState reads the state saved to path, or returns an empty state if
+// there's none
+func LoadState(path string) (*State, error) {
+	state := &State{
+		lastSeen: make(map[string]string),
+		seen:     make(map[string]bool),
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the watch state %s: %v", path, err)
+	}
+
+	var file stateFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse the watch state %s: %v", path, err)
+	}
+	for target, timestamp := range file.LastSeen {
+		state.lastSeen[target] = timestamp
+	}
+	for _, key := range file.Seen {
+		state.seen[key] = true
+	}
+	return state, nil
+}
+
+// Save writes the state to path, replacing it at once so that it's never
+// left half written
+func (s *State) Save(path string) error {
+	s.mu.Lock()
+	file := stateFile{
+		LastSeen: s.lastSeen,
+		Seen:     make([]string, 0, len(s.seen)),
+	}
+	for key := range s.seen {
+		file.Seen = append(file.Seen, key)
+	}
+	sort.Strings(file.Seen)
+	j, err := json.MarshalIndent(file, "", "  ")
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return fmt.Errorf("failed to write the watch state %s: %v", path, err)
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(append(j, '\n'))
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write the watch state %s: %v", path, err)
+	}
+	return nil
+}
+
+// LastSeen returns the timestamp of the newest snapshot seen of target
+func (s *State) LastSeen(target string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lastSeen[target]
+}
+
+// SetLastSeen records timestamp as the newest snapshot seen of target, if
+// it's newer than the one recorded
+func (s *State) SetLastSeen(target, timestamp string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if timestamp > s.lastSeen[target] {
+		s.lastSeen[target] = timestamp
+	}
+}
+
+func (s *State) Update(key []byte, update func(record *dedup.Record) *dedup.Record) error {
+	k := hex.EncodeToString(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var record *dedup.Record
+	if s.seen[k] {
+		record = &dedup.Record{}
+	}
+	if update(record) != nil {
+		s.seen[k] = true
+	}
+	return nil
+}
+
+// Each does nothing, since records aren't kept
+func (s *State) Each(fn func(record dedup.Record) error) error {
+	return nil
+}
+
+func (s *State) Close() error {
+	return nil
+}
+
+// Watcher polls the Wayback Machine for new snapshots of targets, runs
+// modules on them, and writes only the values it didn't write before
+type Watcher struct {
+	// Options of the scans, of which Target and Deduper are set by the
+	// watcher
+	Options   chronos.Options
+	Targets   []string
+	Interval  time.Duration
+	State     *State
+	StatePath string
+	// Dedup is the key values are compared by
+	Dedup string
+	Write func(chronos.Result)
+}
+
+// Run polls every interval until ctx is canceled
+func (w *Watcher) Run(ctx context.Context) error {
+	log := w.Options.Logger
+	if log == nil {
+		log = logger.Discard()
+	}
+	deduper, err := dedup.New(w.Dedup, false, w.State)
+	if err != nil {
+		return err
+	}
+
+	for {
+		for _, target := range w.Targets {
+			err := w.poll(ctx, log, deduper, target)
+			if ctx.Err() != nil {
+				return nil
+			}
+			if err != nil {
+				log.Error.Println(err)
+			}
+		}
+
+		log.Info.Printf("Next poll in %s\n", w.Interval)
+		select {
+		case <-time.After(w.Interval):
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+func (w *Watcher) poll(ctx context.Context, log *logger.Logger, deduper *dedup.Deduper, target string) error {
+	options := w.Options
+	options.Target = target
+	options.Deduper = deduper
+	from, err := w.from(target)
+	if err != nil {
+		return err
+	}
+	options.Filters.From = from
+
+	if from == "" {
+		log.Info.Printf("Polling %s for snapshots of its whole history, set -from to start later\n", target)
+	} else {
+		log.Info.Printf("Polling %s for snapshots since %s\n", target, from)
+	}
+	scanner := chronos.NewScanner(options)
+	results, err := scanner.Run(ctx)
+	if errors.Is(err, wayback.ErrNoSnapshots) {
+		log.Info.Printf("No new snapshots of %s\n", target)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	count := 0
+	for result := range results {
+		w.Write(result)
+		count++
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	log.Info.Printf("Found %d new snapshots of %s and wrote %d new results\n", scanner.Snapshots(), target, count)
+
+	lastSeen := scanner.Newest()
+	if failed := scanner.OldestFailed(); failed != "" {
+		// Snapshots from the oldest one that couldn't be fetched are
+		// searched for again by the next poll
+		t, err := wayback.ParseTimestamp(failed)
+		if err != nil {
+			return fmt.Errorf("invalid timestamp of a snapshot of %s: %v", target, err)
+		}
+		lastSeen = t.Add(-time.Second).Format("20060102150405")
+		log.Info.Printf("Snapshots of %s since %s will be searched for again, since some couldn't be fetched\n", target, failed)
+	}
+	w.State.SetLastSeen(target, lastSeen)
+	return w.State.Save(w.StatePath)
+}
+
+// from returns the timestamp to search for snapshots from: a second after
+// the newest snapshot seen of target, or the -from filter if it's later
+func (w *Watcher) from(target string) (string, error) {
+	from := w.Options.Filters.From
+	lastSeen := w.State.LastSeen(target)
+	if lastSeen == "" {
+		return from, nil
+	}
+
+	next, err := wayback.ParseTimestamp(lastSeen)
+	if err != nil {
+		return "", fmt.Errorf("invalid last seen timestamp of %s in the watch state: %v", target, err)
+	}
+	next = next.Add(time.Second)
+	if from != "" {
+		t, err := wayback.ParseTimestamp(from)
+		if err != nil {
+			return "", err
+		}
+		if t.After(next) {
+			return from, nil
+		}
+	}
+	return next.Format("20060102150405"), nil
+}
@@ -1,49 +1,294 @@
 package wayback
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
+	"net/url"
 	"regexp"
+	"strconv"
 	"strings"
 	"sync"
+	"syscall"
+	"time"
 
 	"github.com/mhmdiaa/chronos/v2/pkg/logger"
+	"github.com/mhmdiaa/chronos/v2/pkg/progress"
 )
 
+const DefaultBaseURL = "https://web.archive.org"
+
+// ErrNoSnapshots is returned by searches that find no snapshots
+var ErrNoSnapshots = errors.New("found no snapshots")
+
 type Filters struct {
-	From             string
-	To               string
-	StatusMatchList  string
-	StatusFilterList string
-	MimeMatchList    string
-	MimeFilterList   string
-	Limit            string
-	Interval         string
-	OnePerURL        bool
+	From             string `json:"from,omitempty"`
+	To               string `json:"to,omitempty"`
+	StatusMatchList  string `json:"match_status,omitempty"`
+	StatusFilterList string `json:"filter_status,omitempty"`
+	MimeMatchList    string `json:"match_mime,omitempty"`
+	MimeFilterList   string `json:"filter_mime,omitempty"`
+	Limit            string `json:"limit,omitempty"`
+	Interval         string `json:"snapshot_interval,omitempty"`
+	OnePerURL        bool   `json:"one_per_url,omitempty"`
+	// At selects the snapshot of every URL closest to a date, at most
+	// Tolerance away from it
+	At        string `json:"at,omitempty"`
+	Tolerance string `json:"at_tolerance,omitempty"`
+}
+
+// Options returns the filters that are set, in the form name=value
+func (filters Filters) Options() []string {
+	var options []string
+	add := func(name, value string) {
+		if value != "" {
+			options = append(options, name+"="+value)
+		}
+	}
+	add("from", filters.From)
+	add("to", filters.To)
+	add("match-status", filters.StatusMatchList)
+	add("filter-status", filters.StatusFilterList)
+	add("match-mime", filters.MimeMatchList)
+	add("filter-mime", filters.MimeFilterList)
+	add("limit", filters.Limit)
+	add("snapshot-interval", filters.Interval)
+	if filters.OnePerURL {
+		options = append(options, "one-per-url")
+	}
+	if filters.At != "" {
+		add("at", filters.At)
+		add("at-tolerance", filters.Tolerance)
+	}
+	return options
+}
+
+// window returns the date of At, and the range of dates within Tolerance
+// of it
+func (filters Filters) window() (at, from, to time.Time, err error) {
+	if filters.From != "" || filters.To != "" {
+		return at, from, to, fmt.Errorf("-at can't be combined with -from and -to")
+	}
+	at, err = ParseTimestamp(filters.At)
+	if err != nil {
+		return at, from, to, err
+	}
+	if filters.Tolerance == "" {
+		return at, time.Time{}, time.Time{}, nil
+	}
+
+	unit := filters.Tolerance[len(filters.Tolerance)-1:]
+	n, err := strconv.Atoi(filters.Tolerance[:len(filters.Tolerance)-1])
+	if err != nil || n < 0 {
+		return at, from, to, fmt.Errorf("invalid tolerance %s", filters.Tolerance)
+	}
+	switch unit {
+	case "h":
+		return at, at.Add(-time.Duration(n) * time.Hour), at.Add(time.Duration(n) * time.Hour), nil
+	case "d":
+		return at, at.AddDate(0, 0, -n), at.AddDate(0, 0, n), nil
+	case "m":
+		return at, at.AddDate(0, -n, 0), at.AddDate(0, n, 0), nil
+	case "y":
+		return at, at.AddDate(-n, 0, 0), at.AddDate(n, 0, 0), nil
+	}
+	return at, from, to, fmt.Errorf("invalid tolerance %s", filters.Tolerance)
 }
 
 type Snapshot struct {
 	OriginalURL string
 	SnapshotURL string
+	Timestamp   string
+	MimeType    string
+	StatusCode  string
+	Digest      string
+	Length      string
+	Headers     http.Header
 	Content     string
 }
 
-func SearchForSnapshots(baseURL, target string, filters Filters) ([]Snapshot, error) {
-	searchURL := buildSearchURL(baseURL, target, filters)
+func (snapshot Snapshot) Columns() []string {
+	return []string{"url", "snapshot", "timestamp", "mime", "status", "digest", "length"}
+}
+
+func (snapshot Snapshot) Rows() [][]string {
+	return [][]string{{snapshot.OriginalURL, snapshot.SnapshotURL, snapshot.Timestamp, snapshot.MimeType, snapshot.StatusCode, snapshot.Digest, snapshot.Length}}
+}
+
+func (snapshot Snapshot) Group() (string, string) {
+	return snapshot.OriginalURL, "snapshots"
+}
+
+const timestampLayout = "20060102150405"
+
+// ParseTimestamp parses a Wayback timestamp of 4 to 14 digits. Missing
+// digits are taken from the start of the period, so 2017 is 2017-01-01.
+func ParseTimestamp(timestamp string) (time.Time, error) {
+	if len(timestamp) < 4 || len(timestamp) > 14 {
+		return time.Time{}, fmt.Errorf("invalid timestamp %s", timestamp)
+	}
+	full := timestamp + "0101000000"[len(timestamp)-4:]
+	t, err := time.Parse(timestampLayout, full)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid timestamp %s", timestamp)
+	}
+	return t, nil
+}
+
+// URLKey identifies an archived URL regardless of its scheme, default port
+// and fragment
+func URLKey(rawURL string) string {
+	if i := strings.Index(rawURL, "://"); i >= 0 {
+		rawURL = rawURL[i+3:]
+	}
+	if i := strings.Index(rawURL, "#"); i >= 0 {
+		rawURL = rawURL[:i]
+	}
+	host, rest, _ := strings.Cut(rawURL, "/")
+	host = strings.TrimSuffix(strings.TrimSuffix(strings.ToLower(host), ":80"), ":443")
+	return host + "/" + rest
+}
+
+// Host returns the lowercase host of an archived URL, which may not have a
+// scheme
+func Host(rawURL string) string {
+	if !strings.Contains(rawURL, "://") {
+		rawURL = "http://" + rawURL
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(u.Hostname())
+}
+
+// HostDir returns the name of the directory files of an archived URL are
+// written to: its lowercase host and port, separated by an underscore.
+// Characters that can't be used in file names are replaced, and names made of
+// dots are escaped so that they don't refer to a parent directory. It returns
+// an empty string if the URL has no host.
+func HostDir(rawURL string) string {
+	if !strings.Contains(rawURL, "://") {
+		rawURL = "http://" + rawURL
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return ""
+	}
+	name := SafeName(strings.ToLower(u.Host))
+	if strings.Trim(name, ".") == "" {
+		name = "_" + name
+	}
+	return name
+}
+
+// SafeName replaces characters that can't be used in file names
+func SafeName(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case '/', '\\', ':', '*', '?', '"', '<', '>', '|':
+			return '_'
+		}
+		return r
+	}, name)
+}
+
+// Client searches for and fetches snapshots from the Wayback Machine
+type Client struct {
+	baseURL string
+	http    *http.Client
+	logger  *logger.Logger
+}
+
+// NewClient creates a client of the Wayback Machine at baseURL, such as
+// https://web.archive.org. httpClient defaults to http.DefaultClient, and
+// log to a logger discarding everything.
+func NewClient(baseURL string, httpClient *http.Client, log *logger.Logger) *Client {
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	if log == nil {
+		log = logger.Discard()
+	}
+	return &Client{baseURL: baseURL, http: httpClient, logger: log}
+}
+
+func (c *Client) get(ctx context.Context, url string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.http.Do(req)
+}
+
+func (c *Client) SearchForSnapshots(ctx context.Context, target string, filters Filters) ([]Snapshot, error) {
+	var at time.Time
+	if filters.At != "" {
+		// Without a tolerance, every snapshot of every URL would be listed
+		if filters.Tolerance == "" && strings.Contains(target, "*") {
+			return nil, fmt.Errorf("-at-tolerance can't be empty with a wildcard target like %s", target)
+		}
+		var from, to time.Time
+		var err error
+		at, from, to, err = filters.window()
+		if err != nil {
+			return nil, err
+		}
+		if !from.IsZero() {
+			filters.From, filters.To = from.Format(timestampLayout), to.Format(timestampLayout)
+		}
+	}
+	searchURL := buildSearchURL(c.baseURL, target, filters)
 
-	resp, err := http.Get(searchURL)
+	resp, err := c.get(ctx, searchURL)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get search results for %s: %v", target, err)
 	}
 	defer resp.Body.Close()
 
-	return parseSearchResults(baseURL, resp.Body, target)
+	snapshots, err := parseSearchResults(c.baseURL, resp.Body, target)
+	if err != nil || filters.At == "" {
+		return snapshots, err
+	}
+	return closestSnapshots(snapshots, at), nil
+}
+
+// closestSnapshots returns the snapshot of every URL closest to at, in the
+// order the URLs were found. Snapshots equally close are resolved in favor
+// of the earlier one.
+func closestSnapshots(snapshots []Snapshot, at time.Time) []Snapshot {
+	type candidate struct {
+		index    int
+		distance time.Duration
+	}
+	var closest []Snapshot
+	best := make(map[string]candidate)
+	for _, snapshot := range snapshots {
+		t, err := ParseTimestamp(snapshot.Timestamp)
+		if err != nil {
+			continue
+		}
+		key := URLKey(snapshot.OriginalURL)
+		distance := t.Sub(at).Abs()
+		current, exists := best[key]
+		switch {
+		case !exists:
+			best[key] = candidate{index: len(closest), distance: distance}
+			closest = append(closest, snapshot)
+		case distance < current.distance || (distance == current.distance && snapshot.Timestamp < closest[current.index].Timestamp):
+			best[key] = candidate{index: current.index, distance: distance}
+			closest[current.index] = snapshot
+		}
+	}
+	return closest
 }
 
 func buildSearchURL(baseURL, target string, filters Filters) string {
-	searchURL := fmt.Sprintf("%s/cdx/search/cdx?output=json&fl=timestamp,original", baseURL)
+	searchURL := fmt.Sprintf("%s/cdx/search/cdx?output=json&fl=timestamp,original,mimetype,statuscode,digest,length", baseURL)
 	searchURL += "&url=" + target
 	if filters.From != "" {
 		searchURL += "&from=" + filters.From
@@ -56,7 +301,15 @@ func buildSearchURL(baseURL, target string, filters Filters) string {
 	searchURL += formatFilterParams(filters.MimeMatchList, "mimetype", false)
 	searchURL += formatFilterParams(filters.MimeFilterList, "mimetype", true)
 	searchURL += formatFilterParams("warc/revisit", "mimetype", true)
-	searchURL += "&limit=" + filters.Limit
+
+	// Every snapshot in the window is needed to find the closest one to the
+	// date, so the limit and collapsing don't apply
+	if filters.At != "" {
+		return searchURL
+	}
+	if filters.Limit != "" {
+		searchURL += "&limit=" + filters.Limit
+	}
 
 	comparedDigits := getComparedDigits(filters.Interval)
 	if comparedDigits != "" {
@@ -113,11 +366,10 @@ func parseSearchResults(baseURL string, body io.Reader, target string) ([]Snapsh
 
 	err = json.Unmarshal(data, &results)
 	if err != nil {
-		fmt.Println(string(data))
 		return nil, fmt.Errorf("failed to deserialize search results for %s: %v", target, err)
 	}
 	if len(results) == 0 {
-		return nil, fmt.Errorf("found no snapshots of %s", target)
+		return nil, fmt.Errorf("%w of %s", ErrNoSnapshots, target)
 	}
 
 	return convertResultsToSnapshots(baseURL, results), nil
@@ -127,9 +379,17 @@ func convertResultsToSnapshots(baseURL string, results [][]string) []Snapshot {
 	var snapshots []Snapshot
 	// The first item in the list is column names
 	for _, s := range results[1:] {
+		if len(s) < 6 {
+			continue
+		}
 		snapshot := Snapshot{
 			OriginalURL: s[1],
 			SnapshotURL: formatSnapshotResponseIntoURL(baseURL, s),
+			Timestamp:   s[0],
+			MimeType:    s[2],
+			StatusCode:  s[3],
+			Digest:      s[4],
+			Length:      s[5],
 		}
 		snapshots = append(snapshots, snapshot)
 	}
@@ -143,38 +403,125 @@ func formatSnapshotResponseIntoURL(baseURL string, snapshot []string) string {
 	return fmt.Sprintf("%s/web/%sif_/%s", baseURL, timestamp, original)
 }
 
-func FetchSnapshots(snapshotLocations chan Snapshot, snapshots chan Snapshot, wg *sync.WaitGroup) {
+// FetchError describes a snapshot that couldn't be fetched. Cause is a short
+// description of what went wrong, suitable for grouping errors.
+type FetchError struct {
+	URL   string
+	Cause string
+	Err   error
+}
+
+func (e *FetchError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *FetchError) Unwrap() error {
+	return e.Err
+}
+
+func (c *Client) FetchSnapshots(ctx context.Context, snapshotLocations chan Snapshot, snapshots chan Snapshot, wg *sync.WaitGroup, tracker *progress.Tracker) {
 	defer wg.Done()
 	for location := range snapshotLocations {
-		content, err := GetSnapshotContent(location.SnapshotURL)
+		tracker.FetchStarted()
+		body, headers, err := c.fetchSnapshot(ctx, location.SnapshotURL)
 		if err != nil {
-			logger.Error.Print(err)
+			tracker.FetchFailed(err.Cause)
+			if ctx.Err() != nil {
+				continue
+			}
+			c.logger.Error.Print(err)
+			c.logger.LogRecord(logger.Record{
+				Type:        logger.RecordError,
+				URL:         location.OriginalURL,
+				SnapshotURL: location.SnapshotURL,
+				Stage:       "fetch",
+				Class:       err.Cause,
+				Message:     err.Error(),
+			})
 			continue
 		}
+		tracker.FetchSucceeded(len(body))
 
-		snapshot := Snapshot{
-			OriginalURL: location.OriginalURL,
-			SnapshotURL: location.SnapshotURL,
-			Content:     content,
-		}
+		snapshot := location
+		snapshot.Headers = headers
+		snapshot.Content = removeWaybackModifications(string(body))
 		snapshots <- snapshot
 	}
 }
 
-func GetSnapshotContent(url string) (string, error) {
-	resp, err := http.Get(url)
+func (c *Client) GetSnapshotContent(ctx context.Context, url string) (string, error) {
+	body, _, err := c.fetchSnapshot(ctx, url)
+	if err != nil {
+		return "", err
+	}
+	return removeWaybackModifications(string(body)), nil
+}
+
+func (c *Client) fetchSnapshot(ctx context.Context, url string) ([]byte, http.Header, *FetchError) {
+	resp, err := c.get(ctx, url)
 	if err != nil {
-		return "", fmt.Errorf("failed to get snapshot %s: %v", url, err)
+		return nil, nil, &FetchError{
+			URL:   url,
+			Cause: classifyError(err),
+			Err:   fmt.Errorf("failed to get snapshot %s: %v", url, err),
+		}
 	}
 	defer resp.Body.Close()
 
+	if isWaybackFailure(resp) {
+		return nil, nil, &FetchError{
+			URL:   url,
+			Cause: fmt.Sprintf("http %d", resp.StatusCode),
+			Err:   fmt.Errorf("failed to get snapshot %s: %s", url, resp.Status),
+		}
+	}
+
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return "", fmt.Errorf("failed to read snapshot %s: %v", url, err)
+		cause := classifyError(err)
+		if cause == "network" {
+			cause = "read"
+		}
+		return nil, nil, &FetchError{
+			URL:   url,
+			Cause: cause,
+			Err:   fmt.Errorf("failed to read snapshot %s: %v", url, err),
+		}
 	}
-	content := string(body)
 
-	return removeWaybackModifications(content), nil
+	return body, resp.Header, nil
+}
+
+// isWaybackFailure reports whether a response is an error of the Wayback
+// Machine itself, like rate limiting or an overloaded server, rather than an
+// archived response. Archived responses have a Memento-Datetime header, and
+// are passed to modules whatever their status is.
+func isWaybackFailure(resp *http.Response) bool {
+	if resp.Header.Get("Memento-Datetime") != "" {
+		return false
+	}
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+}
+
+func classifyError(err error) string {
+	var netErr net.Error
+	var dnsErr *net.DNSError
+	switch {
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	case errors.As(err, &dnsErr):
+		return "dns"
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return "timeout"
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return "connection refused"
+	case errors.Is(err, syscall.ECONNRESET):
+		return "connection reset"
+	case errors.Is(err, io.ErrUnexpectedEOF), errors.Is(err, io.EOF):
+		return "unexpected eof"
+	default:
+		return "network"
+	}
 }
 
 func removeWaybackModifications(content string) string {